@@ -0,0 +1,307 @@
+package socketify
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionInfo is a snapshot of a connection, as listed by the admin handler
+type ConnectionInfo struct {
+	ID           string                 `json:"id"`
+	RemoteAddr   string                 `json:"remote_addr"`
+	ConnectedAt  time.Time              `json:"connected_at"`
+	LastActivity time.Time              `json:"last_activity"`
+	Attributes   map[string]interface{} `json:"attributes"`
+	Rooms        []string               `json:"rooms"`
+	// QueueDepth is the number of write calls waiting for the connection's writer or writing
+	QueueDepth int64 `json:"queue_depth"`
+}
+
+// ConnectionDetails adds the state of rate limits, errors and handlers to ConnectionInfo
+type ConnectionDetails struct {
+	ConnectionInfo
+	UpdateTypes   []string         `json:"update_types"`
+	RawHandler    bool             `json:"raw_handler"`
+	RateLimits    []RateLimitState `json:"rate_limits"`
+	DroppedErrors uint64           `json:"dropped_errors"`
+	Topics        []string         `json:"topics,omitempty"`
+}
+
+// Info returns a snapshot of the connection
+func (c *Connection) Info() ConnectionInfo {
+	c.attributesLocker.Lock()
+	attributes := make(map[string]interface{}, len(c.attributes))
+	for key, value := range c.attributes {
+		attributes[key] = value
+	}
+	c.attributesLocker.Unlock()
+
+	return ConnectionInfo{
+		ID:           c.id,
+		RemoteAddr:   c.ws.RemoteAddr().String(),
+		ConnectedAt:  c.connectedAt,
+		LastActivity: c.LastActivity(),
+		Attributes:   attributes,
+		Rooms:        c.Rooms(),
+		QueueDepth:   atomic.LoadInt64(&c.writer.queued),
+	}
+}
+
+func (c *Connection) details() ConnectionDetails {
+	c.handlersLocker.Lock()
+	updateTypes := make([]string, 0, len(c.handlers))
+	for updateType := range c.handlers {
+		updateTypes = append(updateTypes, updateType)
+	}
+	rawHandler := c.rawHandler != nil
+	c.handlersLocker.Unlock()
+
+	sort.Strings(updateTypes)
+
+	rateLimits := c.RateLimits()
+	if rateLimits == nil {
+		rateLimits = []RateLimitState{}
+	}
+
+	return ConnectionDetails{
+		ConnectionInfo: c.Info(),
+		UpdateTypes:    updateTypes,
+		RawHandler:     rawHandler,
+		RateLimits:     rateLimits,
+		DroppedErrors:  c.DroppedErrors(),
+		Topics:         c.Topics(),
+	}
+}
+
+// AdminBasicAuth returns an auth check for AdminHandler accepting HTTP basic auth with username and password
+func AdminBasicAuth(username, password string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		u, p, ok := r.BasicAuth()
+		if !ok {
+			return false
+		}
+
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+
+		return userOK && passOK
+	}
+}
+
+// AdminHandler returns an http.Handler to inspect and manage live connections, it requires EnableStorage()
+// Every request must pass auth, a nil auth rejects everything. Mount it with http.StripPrefix:
+//
+//	mux.Handle("/admin/", http.StripPrefix("/admin", server.AdminHandler(socketify.AdminBasicAuth("admin", secret))))
+//
+// Routes:
+//
+//	GET  /connections            list connections
+//	GET  /connections/{id}       show a connection
+//	POST /connections/{id}/close close a connection, body: {"code": 4000, "reason": ""}, code defaults to 1000
+//	POST /connections/{id}/send  send an update, body: {"type": "", "data": {}, "extra": ""}
+func (s *Server) AdminHandler(auth func(r *http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil || !auth(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="socketify"`)
+			writeAdminError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		if s.storage == nil {
+			writeAdminError(w, http.StatusNotFound, ErrStorageDisabled.Error())
+			return
+		}
+
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if parts[0] != "connections" || len(parts) > 3 {
+			writeAdminError(w, http.StatusNotFound, "not_found")
+			return
+		}
+
+		if len(parts) == 1 {
+			if r.Method != http.MethodGet {
+				writeAdminError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+				return
+			}
+
+			s.adminList(w)
+			return
+		}
+
+		connection := s.storage.GetClientByID(parts[1])
+		if connection == nil {
+			writeAdminError(w, http.StatusNotFound, "connection_not_found")
+			return
+		}
+
+		action := ""
+		if len(parts) == 3 {
+			action = parts[2]
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			writeAdminJSON(w, http.StatusOK, connection.details())
+		case action == "close" && r.Method == http.MethodPost:
+			s.adminClose(w, r, connection)
+		case action == "send" && r.Method == http.MethodPost:
+			s.adminSend(w, r, connection)
+		case action == "" || action == "close" || action == "send":
+			writeAdminError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		default:
+			writeAdminError(w, http.StatusNotFound, "not_found")
+		}
+	})
+}
+
+func (s *Server) adminList(w http.ResponseWriter) {
+	s.storage.m.Lock()
+	connections := make([]*Connection, 0, len(s.storage.clients))
+	for _, connection := range s.storage.clients {
+		connections = append(connections, connection)
+	}
+	s.storage.m.Unlock()
+
+	infos := make([]ConnectionInfo, 0, len(connections))
+	for _, connection := range connections {
+		infos = append(infos, connection.Info())
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+
+	writeAdminJSON(w, http.StatusOK, infos)
+}
+
+func (s *Server) adminClose(w http.ResponseWriter, r *http.Request, connection *Connection) {
+	var body struct {
+		Code   int    `json:"code"`
+		Reason string `json:"reason"`
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "invalid_body")
+			return
+		}
+	}
+
+	if body.Code == 0 {
+		body.Code = websocket.CloseNormalClosure
+	}
+
+	if !isSendableCloseCode(body.Code) {
+		writeAdminError(w, http.StatusBadRequest, "invalid_close_code")
+		return
+	}
+
+	connection.logger.Info("Closing connection from admin handler", "code", body.Code, "reason", body.Reason)
+
+	if err := connection.CloseWithCode(body.Code, body.Reason); err != nil {
+		connection.logger.Warn("Error closing connection from admin handler", "error", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminSend(w http.ResponseWriter, r *http.Request, connection *Connection) {
+	var body struct {
+		Type  string          `json:"type"`
+		Data  json.RawMessage `json:"data"`
+		Extra string          `json:"extra"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Type == "" {
+		writeAdminError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+
+	var extra []string
+	if body.Extra != "" {
+		extra = []string{body.Extra}
+	}
+
+	var data interface{}
+	if len(body.Data) > 0 {
+		data = body.Data
+	}
+
+	if err := connection.WriteUpdateContext(r.Context(), body.Type, data, extra...); err != nil {
+		writeAdminError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// Attributes can hold anything, fall back to printing them
+		data, err = json.Marshal(printableAttributes(v))
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, "cant_encode_response")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// isSendableCloseCode reports whether the admin handler may send code in a close frame, see RFC 6455 section 7.4
+// 1004 is reserved, 1005, 1006 and 1015 only report closes locally and 1014 is sent by gateways
+func isSendableCloseCode(code int) bool {
+	switch code {
+	case 1004, websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure, 1014, websocket.CloseTLSHandshake:
+		return false
+	}
+
+	return (code >= 1000 && code <= 1014) || (code >= 3000 && code <= 4999)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: code})
+}
+
+// printableAttributes replaces attribute values that can't be encoded to JSON with their fmt representation
+func printableAttributes(v interface{}) interface{} {
+	printable := func(attributes map[string]interface{}) map[string]interface{} {
+		result := make(map[string]interface{}, len(attributes))
+		for key, value := range attributes {
+			if _, err := json.Marshal(value); err != nil {
+				value = fmt.Sprintf("%+v", value)
+			}
+			result[key] = value
+		}
+		return result
+	}
+
+	switch v := v.(type) {
+	case []ConnectionInfo:
+		infos := make([]ConnectionInfo, len(v))
+		for i, info := range v {
+			info.Attributes = printable(info.Attributes)
+			infos[i] = info
+		}
+		return infos
+	case ConnectionDetails:
+		v.Attributes = printable(v.Attributes)
+		return v
+	}
+
+	return v
+}
@@ -0,0 +1,129 @@
+package socketify_test
+
+import (
+	"encoding/json"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(handler http.Handler, method, path, password, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.SetBasicAuth("admin", password)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	return rec
+}
+
+func TestAdminHandler(t *testing.T) {
+	server := socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).EnableStorage())
+	handler := server.AdminHandler(socketify.AdminBasicAuth("admin", "secret"))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		password   string
+		handler    http.Handler
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "Unauthorized", method: http.MethodGet, path: "/connections", password: "wrong", handler: handler, wantStatus: http.StatusUnauthorized, wantBody: `{"error":"unauthorized"}`},
+		{name: "NilAuth", method: http.MethodGet, path: "/connections", password: "secret", handler: server.AdminHandler(nil), wantStatus: http.StatusUnauthorized, wantBody: `{"error":"unauthorized"}`},
+		{name: "StorageDisabled", method: http.MethodGet, path: "/connections", password: "secret", handler: socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())).AdminHandler(socketify.AdminBasicAuth("admin", "secret")), wantStatus: http.StatusNotFound, wantBody: `{"error":"storage_disabled"}`},
+		{name: "List", method: http.MethodGet, path: "/connections", password: "secret", handler: handler, wantStatus: http.StatusOK, wantBody: `[]`},
+		{name: "ListWrongMethod", method: http.MethodPost, path: "/connections", password: "secret", handler: handler, wantStatus: http.StatusMethodNotAllowed, wantBody: `{"error":"method_not_allowed"}`},
+		{name: "UnknownConnection", method: http.MethodPost, path: "/connections/abc/close", password: "secret", handler: handler, wantStatus: http.StatusNotFound, wantBody: `{"error":"connection_not_found"}`},
+		{name: "UnknownRoute", method: http.MethodGet, path: "/rooms", password: "secret", handler: handler, wantStatus: http.StatusNotFound, wantBody: `{"error":"not_found"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := adminRequest(test.handler, test.method, test.path, test.password, "")
+
+			assert.Equal(t, test.wantStatus, rec.Code)
+			assert.JSONEq(t, test.wantBody, rec.Body.String())
+		})
+	}
+}
+
+func TestAdminHandlerLiveConnections(t *testing.T) {
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).EnableStorage()), func(c *socketify.Connection) {
+		c.HandleUpdate("ping", socketify.DataMapper[socketify.EmptyInput](func(socketify.EmptyInput, ...string) error {
+			return nil
+		}))
+	})
+	handler := server.AdminHandler(socketify.AdminBasicAuth("admin", "secret"))
+
+	got, connection := server.Pair()
+	connection.SetAttribute("user_id", "42")
+	assert.NoError(t, connection.Join("lobby"))
+
+	t.Run("List", func(t *testing.T) {
+		rec := adminRequest(handler, http.MethodGet, "/connections", "secret", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var infos []socketify.ConnectionInfo
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &infos))
+		if assert.Len(t, infos, 1) {
+			assert.Equal(t, connection.ID(), infos[0].ID)
+			assert.Equal(t, map[string]interface{}{"user_id": "42"}, infos[0].Attributes)
+			assert.Equal(t, []string{"lobby"}, infos[0].Rooms)
+		}
+	})
+
+	t.Run("Detail", func(t *testing.T) {
+		// The handler registers update types once the client is paired
+		assert.Eventually(t, func() bool {
+			rec := adminRequest(handler, http.MethodGet, "/connections/"+connection.ID(), "secret", "")
+
+			var details socketify.ConnectionDetails
+			return json.Unmarshal(rec.Body.Bytes(), &details) == nil && len(details.UpdateTypes) == 1
+		}, time.Second, time.Millisecond*10)
+
+		rec := adminRequest(handler, http.MethodGet, "/connections/"+connection.ID(), "secret", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var details socketify.ConnectionDetails
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &details))
+		assert.Equal(t, connection.ID(), details.ID)
+		assert.Equal(t, []string{"lobby"}, details.Rooms)
+		assert.Equal(t, []string{"ping"}, details.UpdateTypes)
+		assert.Equal(t, []socketify.RateLimitState{}, details.RateLimits)
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		rec := adminRequest(handler, http.MethodPost, "/connections/"+connection.ID()+"/send", "secret", `{"type": "notice", "data": {"text": "hi"}, "extra": "7"}`)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		update := got.ExpectUpdate("notice", time.Second)
+		assert.JSONEq(t, `{"text": "hi"}`, string(update.Data))
+		assert.Equal(t, "7", update.Extra)
+	})
+
+	t.Run("InvalidCloseCode", func(t *testing.T) {
+		for _, code := range []string{"999", "1004", "1005", "1006", "1014", "1015", "2000", "5000"} {
+			rec := adminRequest(handler, http.MethodPost, "/connections/"+connection.ID()+"/close", "secret", `{"code": `+code+`}`)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, code)
+			assert.JSONEq(t, `{"error":"invalid_close_code"}`, rec.Body.String(), code)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		rec := adminRequest(handler, http.MethodPost, "/connections/"+connection.ID()+"/close", "secret", `{"code": 4000, "reason": "bye"}`)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		got.AssertClosedWith(4000)
+
+		assert.Eventually(t, func() bool {
+			return adminRequest(handler, http.MethodGet, "/connections/"+connection.ID(), "secret", "").Code == http.StatusNotFound
+		}, time.Second, time.Millisecond*10)
+	})
+}
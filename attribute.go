@@ -0,0 +1,108 @@
+package socketify
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrKeyExists = errors.New("attribute_key_exists")
+
+var (
+	keyNamesLock sync.Mutex
+	keyNames     = map[string]bool{}
+)
+
+// Key is a typed attribute key, create it once with MustNewKey and share it, e.g. var UserID = socketify.MustNewKey[int64]("user_id")
+type Key[T any] struct {
+	name string
+}
+
+// NewKey creates a typed attribute key, it returns ErrKeyExists if a key with the same name exists, whatever its type,
+// so two packages can't share an attribute by accident. Prefix names with your package, e.g. "billing.user_id".
+// The name is the attribute key, SetAttribute(name, ...) sets the key's attribute
+func NewKey[T any](name string) (Key[T], error) {
+	keyNamesLock.Lock()
+	defer keyNamesLock.Unlock()
+
+	if keyNames[name] {
+		return Key[T]{}, fmt.Errorf("%w: %q", ErrKeyExists, name)
+	}
+	keyNames[name] = true
+
+	return Key[T]{name: name}, nil
+}
+
+// MustNewKey is like NewKey but panics if the key exists, it's meant for package level variables
+func MustNewKey[T any](name string) Key[T] {
+	key, err := NewKey[T](name)
+	if err != nil {
+		panic(fmt.Sprintf("socketify: %s", err))
+	}
+
+	return key
+}
+
+// Name returns the attribute key used by SetAttribute, GetAttribute, IndexAttributes and GetClientsByAttributeValue
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+func (k Key[T]) String() string {
+	return k.name
+}
+
+// Get returns the value of key, ok is false if it isn't set or holds a value of another type
+func Get[T any](c Conn, key Key[T]) (val T, ok bool) {
+	v, exists := c.GetAttribute(key.name)
+	if !exists {
+		return val, false
+	}
+
+	val, ok = v.(T)
+	return
+}
+
+// Set sets the value of key
+func Set[T any](c Conn, key Key[T], val T) {
+	c.SetAttribute(key.name, val)
+}
+
+// SetRequestAttribute sets the value of key on an upgrade request, it's copied to the connection by Upgrade
+func SetRequestAttribute[T any](u *UpgradeRequest, key Key[T], val T) *UpgradeRequest {
+	return u.SetAttribute(key.name, val)
+}
+
+// AttributeChange describes a call to SetAttribute, Old is nil and Existed false for new attributes
+type AttributeChange struct {
+	Key     string
+	Old     interface{}
+	New     interface{}
+	Existed bool
+}
+
+// OnAttributeChange registers a hook called after every SetAttribute, on the goroutine that called it
+func (c *Connection) OnAttributeChange(fn func(change AttributeChange)) {
+	c.attributesLocker.Lock()
+	defer c.attributesLocker.Unlock()
+
+	c.attributeHooks = append(c.attributeHooks, fn)
+}
+
+// OnChange registers a hook called after every change of key on c, old is the zero value if key wasn't set
+// or held a value of another type
+func OnChange[T any](c Conn, key Key[T], fn func(old, new T)) {
+	c.OnAttributeChange(func(change AttributeChange) {
+		if change.Key != key.name {
+			return
+		}
+
+		n, ok := change.New.(T)
+		if !ok {
+			return
+		}
+
+		o, _ := change.Old.(T)
+		fn(o, n)
+	})
+}
@@ -0,0 +1,148 @@
+package socketify_test
+
+import (
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Keys are package level, MustNewKey panics when a test runs again with -count
+var (
+	testUserID      = socketify.MustNewKey[int64]("socketify_test.user_id")
+	testOtherUserID = socketify.MustNewKey[int64]("socketify_test.other_user_id")
+	upgradeUserID   = socketify.MustNewKey[int64]("socketify_test.upgrade_user_id")
+)
+
+func TestKey(t *testing.T) {
+	userID, otherUserID := testUserID, testOtherUserID
+
+	tests := []struct {
+		name   string
+		set    func(c socketify.Conn)
+		want   int64
+		wantOk bool
+	}{
+		{
+			name:   "Missing",
+			set:    func(c socketify.Conn) {},
+			wantOk: false,
+		},
+		{
+			name: "Set",
+			set: func(c socketify.Conn) {
+				socketify.Set(c, userID, 42)
+			},
+			want:   42,
+			wantOk: true,
+		},
+		{
+			name: "OtherType",
+			set: func(c socketify.Conn) {
+				c.SetAttribute(userID.Name(), "42")
+			},
+			wantOk: false,
+		},
+		{
+			name: "NoCollision",
+			set: func(c socketify.Conn) {
+				socketify.Set(c, otherUserID, 7)
+			},
+			wantOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := socketifytest.NewFakeConn("1")
+			tt.set(c)
+
+			got, ok := socketify.Get(c, userID)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.Equal(t, "socketify_test.user_id", userID.Name())
+
+	_, err := socketify.NewKey[int64]("socketify_test.user_id")
+	assert.ErrorIs(t, err, socketify.ErrKeyExists)
+	_, err = socketify.NewKey[string]("socketify_test.user_id")
+	assert.ErrorIs(t, err, socketify.ErrKeyExists, "names are unique whatever the type")
+
+	assert.PanicsWithValue(t, `socketify: attribute_key_exists: "socketify_test.user_id"`, func() {
+		socketify.MustNewKey[int64]("socketify_test.user_id")
+	})
+
+	t.Run("OnChange", func(t *testing.T) {
+		c := socketifytest.NewFakeConn("1")
+
+		var changes [][2]int64
+		socketify.OnChange(c, userID, func(old, new int64) {
+			changes = append(changes, [2]int64{old, new})
+		})
+
+		socketify.Set(c, userID, 1)
+		socketify.Set(c, userID, 2)
+		socketify.Set(c, otherUserID, 3)
+		c.SetAttribute(userID.Name(), "ignored")
+
+		assert.Equal(t, [][2]int64{{0, 1}, {1, 2}}, changes)
+	})
+}
+
+func TestUpgradeRequestAttributes(t *testing.T) {
+	userID := upgradeUserID
+
+	server := socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).IndexAttributes(userID.Name()))
+	hs := httptest.NewServer(server)
+	defer hs.Close()
+
+	connections := make(chan *socketify.Connection, 1)
+	go func() {
+		request := <-server.UpgradeRequests()
+		connection, err := socketify.SetRequestAttribute(request, userID, 42).Upgrade()
+		if err != nil {
+			close(connections)
+			return
+		}
+		connections <- connection
+	}()
+
+	client, err := socketify.NewClient("ws" + strings.TrimPrefix(hs.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close(websocket.CloseNormalClosure, "")
+
+	var connection *socketify.Connection
+	select {
+	case connection = <-connections:
+	case <-time.After(time.Second):
+		t.Fatal("connection not upgraded")
+	}
+	if connection == nil {
+		t.Fatal("upgrade failed")
+	}
+	defer connection.Close()
+
+	got, ok := socketify.Get(connection, userID)
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), got)
+	assert.Len(t, server.Storage().GetClientsByAttributeValue(userID.Name(), int64(42)), 1)
+
+	var changes [][2]int64
+	socketify.OnChange(connection, userID, func(old, new int64) {
+		changes = append(changes, [2]int64{old, new})
+	})
+
+	socketify.Set(connection, userID, 43)
+	connection.SetAttribute("other", "ignored")
+
+	assert.Equal(t, [][2]int64{{42, 43}}, changes)
+	assert.Len(t, server.Storage().GetClientsByAttributeValue(userID.Name(), int64(43)), 1)
+}
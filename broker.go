@@ -0,0 +1,402 @@
+package socketify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrClientNotFound  = errors.New("client_not_found")
+	ErrStorageDisabled = errors.New("storage_disabled")
+	ErrBrokerQueueFull = errors.New("broker_queue_full")
+)
+
+const (
+	brokerChannelPrefix    = "socketify."
+	defaultBrokerQueueSize = 1024
+)
+
+// Broker carries messages between server instances, Redis or NATS pub/sub can implement it
+// Messages published to a channel are delivered to every subscriber of the channel, on every instance,
+// including the instance that published them
+type Broker interface {
+	Publish(ctx context.Context, channel string, message []byte) error
+	// Subscribe calls handler for every message published to channel until unsubscribe is called
+	Subscribe(channel string, handler func(message []byte)) (unsubscribe func(), err error)
+}
+
+// brokerMessage is an update sent through the broker
+type brokerMessage struct {
+	Node        string          `json:"node"`
+	Room        string          `json:"room,omitempty"`
+	Type        string          `json:"type"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	Extra       string          `json:"extra,omitempty"`
+	Traceparent string          `json:"traceparent,omitempty"`
+}
+
+func clientChannel(clientID string) string {
+	return brokerChannelPrefix + "client." + clientID
+}
+
+func topicChannel(topic string) string {
+	return brokerChannelPrefix + "topic." + topic
+}
+
+func broadcastChannel() string {
+	return brokerChannelPrefix + "broadcast"
+}
+
+// roomChannel carries the broadcasts of every room, rooms change too often to subscribe to each of them
+func roomChannel() string {
+	return brokerChannelPrefix + "room"
+}
+
+// NodeID identifies the server instance, see options.SetNodeID
+func (s *Server) NodeID() string {
+	return s.opts.nodeID
+}
+
+func (s *Server) newBrokerMessage(ctx context.Context, updateType string, data interface{}, extra ...string) ([]byte, error) {
+	return s.newRoomBrokerMessage(ctx, "", updateType, data, extra...)
+}
+
+// newRoomBrokerMessage is like newBrokerMessage for an update sent to the members of room
+func (s *Server) newRoomBrokerMessage(ctx context.Context, room, updateType string, data interface{}, extra ...string) ([]byte, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	message := brokerMessage{
+		Node:  s.opts.nodeID,
+		Room:  room,
+		Type:  updateType,
+		Data:  b,
+		Extra: strings.Join(extra, "_"),
+	}
+	if span := SpanFromContext(ctx); span != nil && span.Context.IsValid() {
+		message.Traceparent = span.Context.Traceparent()
+	}
+
+	return json.Marshal(message)
+}
+
+// decodeBrokerMessage decodes a message received from the broker, ctx continues the trace of the sender
+func (s *Server) decodeBrokerMessage(b []byte) (context.Context, *brokerMessage, bool) {
+	var message *brokerMessage
+	if err := json.Unmarshal(b, &message); err != nil || message == nil {
+		s.opts.logger.Warn("Invalid broker message", "error", err, "data", string(b))
+		return nil, nil, false
+	}
+
+	ctx := context.Background()
+	if parent, err := ParseTraceparent(message.Traceparent); err == nil {
+		ctx = ContextWithSpan(ctx, &Span{Context: parent})
+	}
+
+	return ctx, message, true
+}
+
+// SendToClient writes an update to the client with clientID, wherever it's connected
+// Without a broker it returns ErrClientNotFound if the client isn't connected to this instance,
+// with a broker the update is handed to the broker and no error is returned for unknown clients
+func (s *Server) SendToClient(clientID, updateType string, data interface{}, extra ...string) error {
+	return s.SendToClientContext(context.Background(), clientID, updateType, data, extra...)
+}
+
+// SendToClientContext is like SendToClient but the update continues the trace of the span in ctx
+func (s *Server) SendToClientContext(ctx context.Context, clientID, updateType string, data interface{}, extra ...string) error {
+	if s.storage != nil {
+		if c := s.storage.GetClientByID(clientID); c != nil {
+			return c.WriteUpdateContext(ctx, updateType, data, extra...)
+		}
+	}
+
+	if s.opts.broker == nil {
+		return ErrClientNotFound
+	}
+
+	message, err := s.newBrokerMessage(ctx, updateType, data, extra...)
+	if err != nil {
+		return err
+	}
+
+	return s.opts.broker.Publish(ctx, clientChannel(clientID), message)
+}
+
+// Broadcast writes an update to every connection of every instance sharing the broker
+// It needs storage, the local connections are written to before Broadcast returns
+func (s *Server) Broadcast(updateType string, data interface{}, extra ...string) error {
+	return s.BroadcastContext(context.Background(), updateType, data, extra...)
+}
+
+// BroadcastContext is like Broadcast but the updates continue the trace of the span in ctx
+func (s *Server) BroadcastContext(ctx context.Context, updateType string, data interface{}, extra ...string) error {
+	if s.storage == nil {
+		return ErrStorageDisabled
+	}
+
+	s.broadcastLocal(ctx, updateType, data, extra...)
+
+	if s.opts.broker == nil {
+		return nil
+	}
+
+	message, err := s.newBrokerMessage(ctx, updateType, data, extra...)
+	if err != nil {
+		return err
+	}
+
+	return s.opts.broker.Publish(ctx, broadcastChannel(), message)
+}
+
+func (s *Server) connections() []*Connection {
+	s.storage.m.Lock()
+	defer s.storage.m.Unlock()
+
+	connections := make([]*Connection, 0, len(s.storage.clients))
+	for _, c := range s.storage.clients {
+		connections = append(connections, c)
+	}
+
+	return connections
+}
+
+func (s *Server) broadcastLocal(ctx context.Context, updateType string, data interface{}, extra ...string) {
+	var wg sync.WaitGroup
+	for _, c := range s.connections() {
+		wg.Add(1)
+		go func(c *Connection) {
+			defer wg.Done()
+
+			if err := c.WriteUpdateContext(ctx, updateType, data, extra...); err != nil {
+				c.logger.Warn("Error broadcasting update", "update_type", updateType, "error", err)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// subscribeBroker subscribes the server to the broadcasts of other instances
+// Broker handlers only queue updates on connections, writing to a slow connection would hold every broker message up
+func (s *Server) subscribeBroker() {
+	_, err := s.opts.broker.Subscribe(broadcastChannel(), func(b []byte) {
+		ctx, message, ok := s.decodeBrokerMessage(b)
+		if !ok || message.Node == s.opts.nodeID {
+			return
+		}
+
+		for _, c := range s.connections() {
+			c.queueBrokerUpdate(ctx, message.Type, message.Data, s.extraOf(message)...)
+		}
+	})
+	if err != nil {
+		s.opts.logger.Error("Error subscribing to broker", "channel", broadcastChannel(), "error", err)
+	}
+
+	_, err = s.opts.broker.Subscribe(roomChannel(), func(b []byte) {
+		ctx, message, ok := s.decodeBrokerMessage(b)
+		if !ok || message.Node == s.opts.nodeID {
+			return
+		}
+
+		for _, c := range s.storage.roomMembers(message.Room) {
+			c.queueBrokerUpdate(ctx, message.Type, message.Data, s.extraOf(message)...)
+		}
+	})
+	if err != nil {
+		s.opts.logger.Error("Error subscribing to broker", "channel", roomChannel(), "error", err)
+	}
+}
+
+// subscribeConnection receives the updates sent to c by other instances
+// It's called during the upgrade and waits for the broker, e.g. a round trip to the TCPBrokerServer, so an update
+// sent to c by another instance once it's connected isn't missed. The unsubscription on close doesn't wait
+func (s *Server) subscribeConnection(c *Connection) {
+	unsubscribe, err := s.opts.broker.Subscribe(clientChannel(c.id), func(b []byte) {
+		ctx, message, ok := s.decodeBrokerMessage(b)
+		if !ok {
+			return
+		}
+
+		c.queueBrokerUpdate(ctx, message.Type, message.Data, s.extraOf(message)...)
+	})
+	if err != nil {
+		c.logger.Error("Error subscribing to broker", "channel", clientChannel(c.id), "error", err)
+		return
+	}
+
+	c.brokerM.Lock()
+	defer c.brokerM.Unlock()
+
+	// The connection may have been closed while subscribing, before it had anything to unsubscribe
+	if c.ctx.Err() != nil {
+		go unsubscribe()
+		return
+	}
+
+	c.brokerUnsubscribe = func() {
+		go unsubscribe()
+	}
+}
+
+// brokerQueue writes the updates received from the broker to a connection in order, without blocking the broker
+// Updates wait in the queue while the connection is slow, they're discarded when it closes or the queue overflows
+type brokerQueue struct {
+	m          sync.Mutex
+	size       int
+	pending    []func()
+	overflowed bool
+	signal     chan struct{}
+}
+
+func newBrokerQueue(size int, done <-chan struct{}) *brokerQueue {
+	q := &brokerQueue{size: size, signal: make(chan struct{}, 1)}
+
+	go q.run(done)
+
+	return q
+}
+
+// push queues fn, it reports true when fn overflowed the queue
+// The pending updates are discarded then, and the ones pushed afterwards are dropped
+func (q *brokerQueue) push(fn func()) bool {
+	q.m.Lock()
+	if q.overflowed {
+		q.m.Unlock()
+		return false
+	}
+
+	if len(q.pending) >= q.size {
+		q.overflowed = true
+		q.pending = nil
+		q.m.Unlock()
+		return true
+	}
+
+	q.pending = append(q.pending, fn)
+	q.m.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+
+	return false
+}
+
+func (q *brokerQueue) pop() func() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if len(q.pending) == 0 {
+		return nil
+	}
+
+	fn := q.pending[0]
+	q.pending[0] = nil
+	q.pending = q.pending[1:]
+
+	return fn
+}
+
+func (q *brokerQueue) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			q.m.Lock()
+			q.pending = nil
+			q.m.Unlock()
+			return
+		case <-q.signal:
+		}
+
+		for fn := q.pop(); fn != nil; fn = q.pop() {
+			fn()
+		}
+	}
+}
+
+// queueBrokerUpdate writes an update received from the broker once the previous ones are written
+// A connection too slow to keep up with its queue is closed with 1013 Try Again Later
+func (c *Connection) queueBrokerUpdate(ctx context.Context, updateType string, data interface{}, extra ...string) {
+	overflowed := c.brokerQueue.push(func() {
+		if err := c.WriteUpdateContext(ctx, updateType, data, extra...); err != nil {
+			c.logger.Warn("Error sending update from broker", "update_type", updateType, "error", err)
+		}
+	})
+
+	if overflowed {
+		c.logger.Warn("Broker queue full, closing connection", "update_type", updateType)
+
+		// Closing writes to the connection and unsubscribes from the broker, it mustn't hold the broker's handler up
+		go c.CloseWithCode(websocket.CloseTryAgainLater, ErrBrokerQueueFull.Error())
+	}
+}
+
+func (s *Server) extraOf(message *brokerMessage) []string {
+	if message.Extra == "" {
+		return nil
+	}
+
+	return []string{message.Extra}
+}
+
+// MemoryBroker is a Broker for servers running in the same process, e.g. in tests
+type MemoryBroker struct {
+	m           sync.RWMutex
+	nextID      int
+	subscribers map[string]map[int]func(message []byte)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subscribers: map[string]map[int]func(message []byte){}}
+}
+
+// Publish calls the handlers of the channel's subscribers before returning
+func (b *MemoryBroker) Publish(_ context.Context, channel string, message []byte) error {
+	b.m.RLock()
+	handlers := make([]func(message []byte), 0, len(b.subscribers[channel]))
+	for _, handler := range b.subscribers[channel] {
+		handlers = append(handlers, handler)
+	}
+	b.m.RUnlock()
+
+	for _, handler := range handlers {
+		handler(append([]byte{}, message...))
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(channel string, handler func(message []byte)) (func(), error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.nextID++
+	id := b.nextID
+
+	if b.subscribers[channel] == nil {
+		b.subscribers[channel] = map[int]func(message []byte){}
+	}
+	b.subscribers[channel][id] = handler
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			b.m.Lock()
+			defer b.m.Unlock()
+
+			delete(b.subscribers[channel], id)
+			if len(b.subscribers[channel]) == 0 {
+				delete(b.subscribers, channel)
+			}
+		})
+	}, nil
+}
@@ -0,0 +1,422 @@
+package socketify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrBrokerClosed = errors.New("broker_closed")
+
+const defaultTCPBrokerWriteTimeout = time.Second * 10
+
+const (
+	tcpBrokerOpSubscribe   = "sub"
+	tcpBrokerOpUnsubscribe = "unsub"
+	tcpBrokerOpPublish     = "pub"
+	tcpBrokerOpMessage     = "msg"
+	tcpBrokerOpAck         = "ack"
+)
+
+// tcpBrokerFrame is a JSON line exchanged between TCPBroker and TCPBrokerServer
+type tcpBrokerFrame struct {
+	Op      string `json:"op"`
+	ID      uint64 `json:"id,omitempty"`
+	Channel string `json:"channel"`
+	Data    []byte `json:"data,omitempty"`
+}
+
+// TCPBrokerServer relays the messages of TCPBroker clients, it's a reference broker to run several instances locally
+// It keeps no state on disk and doesn't authenticate clients, use Redis or NATS in production
+type TCPBrokerServer struct {
+	listener net.Listener
+
+	m            sync.Mutex
+	peers        map[*tcpBrokerPeer]struct{}
+	writeTimeout time.Duration
+	wg           sync.WaitGroup
+}
+
+type tcpBrokerPeer struct {
+	conn     net.Conn
+	m        sync.Mutex
+	encoder  *json.Encoder
+	channels map[string]bool
+}
+
+// send writes a frame to the peer, a peer that doesn't read it within timeout is disconnected
+func (p *tcpBrokerPeer) send(frame tcpBrokerFrame, timeout time.Duration) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	_ = p.conn.SetWriteDeadline(time.Now().Add(timeout))
+
+	err := p.encoder.Encode(frame)
+	if err != nil {
+		_ = p.conn.Close()
+	}
+
+	return err
+}
+
+// ListenTCPBroker starts a TCPBrokerServer on address, e.g. "127.0.0.1:0" for an ephemeral port
+func ListenTCPBroker(address string) (*TCPBrokerServer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TCPBrokerServer{
+		listener:     listener,
+		peers:        map[*tcpBrokerPeer]struct{}{},
+		writeTimeout: defaultTCPBrokerWriteTimeout,
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s, nil
+}
+
+// Addr returns the address clients dial
+func (s *TCPBrokerServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// SetWriteTimeout sets how long a client may take to read a message before it's disconnected, it defaults to 10s
+// A client that stopped reading would otherwise hold up the messages of every other client
+func (s *TCPBrokerServer) SetWriteTimeout(timeout time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.writeTimeout = timeout
+}
+
+// Close stops accepting clients and disconnects the connected ones
+func (s *TCPBrokerServer) Close() error {
+	err := s.listener.Close()
+
+	s.m.Lock()
+	for peer := range s.peers {
+		_ = peer.conn.Close()
+	}
+	s.m.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *TCPBrokerServer) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		peer := &tcpBrokerPeer{conn: conn, encoder: json.NewEncoder(conn), channels: map[string]bool{}}
+
+		s.m.Lock()
+		s.peers[peer] = struct{}{}
+		s.m.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(peer)
+	}
+}
+
+func (s *TCPBrokerServer) serve(peer *tcpBrokerPeer) {
+	defer s.wg.Done()
+	defer func() {
+		s.m.Lock()
+		delete(s.peers, peer)
+		s.m.Unlock()
+
+		_ = peer.conn.Close()
+	}()
+
+	decoder := json.NewDecoder(bufio.NewReader(peer.conn))
+	for {
+		var frame tcpBrokerFrame
+		if err := decoder.Decode(&frame); err != nil {
+			return
+		}
+
+		switch frame.Op {
+		case tcpBrokerOpSubscribe, tcpBrokerOpUnsubscribe:
+			s.m.Lock()
+			if frame.Op == tcpBrokerOpSubscribe {
+				peer.channels[frame.Channel] = true
+			} else {
+				delete(peer.channels, frame.Channel)
+			}
+			timeout := s.writeTimeout
+			s.m.Unlock()
+
+			if err := peer.send(tcpBrokerFrame{Op: tcpBrokerOpAck, ID: frame.ID, Channel: frame.Channel}, timeout); err != nil {
+				return
+			}
+		case tcpBrokerOpPublish:
+			s.m.Lock()
+			var receivers []*tcpBrokerPeer
+			for p := range s.peers {
+				if p.channels[frame.Channel] {
+					receivers = append(receivers, p)
+				}
+			}
+			timeout := s.writeTimeout
+			s.m.Unlock()
+
+			// Receivers are written to concurrently so a slow one only delays the others until it times out,
+			// waiting for all of them keeps the messages of this peer in order
+			var wg sync.WaitGroup
+			for _, p := range receivers {
+				wg.Add(1)
+				go func(p *tcpBrokerPeer) {
+					defer wg.Done()
+
+					_ = p.send(tcpBrokerFrame{Op: tcpBrokerOpMessage, Channel: frame.Channel, Data: frame.Data}, timeout)
+				}(p)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// TCPBroker is a Broker connected to a TCPBrokerServer
+// Handlers are called in order on the goroutine reading from the server, they must not call Subscribe
+type TCPBroker struct {
+	conn    net.Conn
+	writeM  sync.Mutex
+	encoder *json.Encoder
+
+	m           sync.Mutex
+	nextID      uint64
+	subscribers map[string]map[uint64]func(message []byte)
+	channels    map[string]*tcpBrokerChannel
+	acks        map[uint64]chan struct{}
+	done        chan struct{}
+}
+
+// tcpBrokerChannel serialises the subscription changes of a channel, so the server sees them in the order they're
+// decided and a Subscribe can't return before the subscription it relies on is acknowledged
+type tcpBrokerChannel struct {
+	m sync.Mutex
+	// refs counts the Subscribe and unsubscribe calls using the channel, it's removed when none is left
+	refs int
+}
+
+// DialTCPBroker connects to the TCPBrokerServer at address
+func DialTCPBroker(address string) (*TCPBroker, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &TCPBroker{
+		conn:        conn,
+		encoder:     json.NewEncoder(conn),
+		subscribers: map[string]map[uint64]func(message []byte){},
+		channels:    map[string]*tcpBrokerChannel{},
+		acks:        map[uint64]chan struct{}{},
+		done:        make(chan struct{}),
+	}
+
+	go b.read()
+
+	return b, nil
+}
+
+func (b *TCPBroker) write(ctx context.Context, frame tcpBrokerFrame) error {
+	b.writeM.Lock()
+	defer b.writeM.Unlock()
+
+	select {
+	case <-b.done:
+		return ErrBrokerClosed
+	default:
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Second * 10)
+	}
+	_ = b.conn.SetWriteDeadline(deadline)
+
+	return b.encoder.Encode(frame)
+}
+
+func (b *TCPBroker) Publish(ctx context.Context, channel string, message []byte) error {
+	return b.write(ctx, tcpBrokerFrame{Op: tcpBrokerOpPublish, Channel: channel, Data: message})
+}
+
+// Subscribe returns once the server acknowledged the subscription, so no message published afterwards is missed
+// The handlers of a channel are only added and removed while holding the channel's lock, the first handler
+// subscribes on the server and the last one unsubscribes
+func (b *TCPBroker) Subscribe(channel string, handler func(message []byte)) (func(), error) {
+	ch := b.lockChannel(channel)
+	defer b.unlockChannel(channel, ch)
+
+	b.m.Lock()
+	b.nextID++
+	id := b.nextID
+	first := len(b.subscribers[channel]) == 0
+	b.m.Unlock()
+
+	if first {
+		if err := b.request(tcpBrokerOpSubscribe, channel); err != nil {
+			return nil, err
+		}
+	}
+
+	b.m.Lock()
+	if b.subscribers[channel] == nil {
+		b.subscribers[channel] = map[uint64]func(message []byte){}
+	}
+	b.subscribers[channel][id] = handler
+	b.m.Unlock()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			ch := b.lockChannel(channel)
+			defer b.unlockChannel(channel, ch)
+
+			if b.remove(channel, id) {
+				_ = b.request(tcpBrokerOpUnsubscribe, channel)
+			}
+		})
+	}, nil
+}
+
+// lockChannel locks the subscription changes of channel, unlock it with unlockChannel
+func (b *TCPBroker) lockChannel(channel string) *tcpBrokerChannel {
+	b.m.Lock()
+	ch := b.channels[channel]
+	if ch == nil {
+		ch = &tcpBrokerChannel{}
+		b.channels[channel] = ch
+	}
+	ch.refs++
+	b.m.Unlock()
+
+	ch.m.Lock()
+
+	return ch
+}
+
+func (b *TCPBroker) unlockChannel(channel string, ch *tcpBrokerChannel) {
+	ch.m.Unlock()
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	ch.refs--
+	if ch.refs == 0 {
+		delete(b.channels, channel)
+	}
+}
+
+// remove removes a handler and reports whether it was the last one of the channel
+func (b *TCPBroker) remove(channel string, id uint64) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	delete(b.subscribers[channel], id)
+	if len(b.subscribers[channel]) > 0 {
+		return false
+	}
+
+	delete(b.subscribers, channel)
+
+	return true
+}
+
+// request sends a subscription change and waits for its ack
+func (b *TCPBroker) request(op, channel string) error {
+	ack := make(chan struct{})
+
+	b.m.Lock()
+	b.nextID++
+	id := b.nextID
+	b.acks[id] = ack
+	b.m.Unlock()
+
+	defer func() {
+		b.m.Lock()
+		delete(b.acks, id)
+		b.m.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	if err := b.write(ctx, tcpBrokerFrame{Op: op, ID: id, Channel: channel}); err != nil {
+		return err
+	}
+
+	select {
+	case <-ack:
+		return nil
+	case <-b.done:
+		return ErrBrokerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *TCPBroker) read() {
+	defer b.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(b.conn))
+	for {
+		var frame tcpBrokerFrame
+		if err := decoder.Decode(&frame); err != nil {
+			return
+		}
+
+		switch frame.Op {
+		case tcpBrokerOpAck:
+			b.m.Lock()
+			if ack := b.acks[frame.ID]; ack != nil {
+				close(ack)
+				delete(b.acks, frame.ID)
+			}
+			b.m.Unlock()
+		case tcpBrokerOpMessage:
+			b.m.Lock()
+			handlers := make([]func(message []byte), 0, len(b.subscribers[frame.Channel]))
+			for _, handler := range b.subscribers[frame.Channel] {
+				handlers = append(handlers, handler)
+			}
+			b.m.Unlock()
+
+			for _, handler := range handlers {
+				handler(frame.Data)
+			}
+		}
+	}
+}
+
+// Close disconnects from the server, Publish and Subscribe return ErrBrokerClosed afterwards
+func (b *TCPBroker) Close() error {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	select {
+	case <-b.done:
+		return nil
+	default:
+	}
+
+	close(b.done)
+
+	return b.conn.Close()
+}
@@ -0,0 +1,270 @@
+package socketify_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBroker(t *testing.T) {
+	tests := []struct {
+		name    string
+		brokers func(t *testing.T) (socketify.Broker, socketify.Broker)
+	}{
+		{
+			name: "Memory",
+			brokers: func(t *testing.T) (socketify.Broker, socketify.Broker) {
+				broker := socketify.NewMemoryBroker()
+				return broker, broker
+			},
+		},
+		{
+			name: "TCP",
+			brokers: func(t *testing.T) (socketify.Broker, socketify.Broker) {
+				server, err := socketify.ListenTCPBroker("127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() {
+					_ = server.Close()
+				})
+
+				a, err := socketify.DialTCPBroker(server.Addr())
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := socketify.DialTCPBroker(server.Addr())
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() {
+					_ = a.Close()
+					_ = b.Close()
+				})
+
+				return a, b
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			brokerA, brokerB := tt.brokers(t)
+
+			serverA := socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetNodeID("a").SetBroker(brokerA).EnableTopics())
+			serverB := socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetNodeID("b").SetBroker(brokerB).EnableTopics())
+
+			ordersA := socketify.NewTopic[orderParams, order](serverA, "orders")
+			ordersB := socketify.NewTopic[orderParams, order](serverB, "orders")
+
+			pipeA := socketifytest.NewPipeServer(t, serverA, nil)
+			clientA, connectionA := pipeA.Pair()
+			clientB, connectionB := socketifytest.NewPipeServer(t, serverB, nil).Pair()
+
+			assert.NoError(t, serverB.SendToClient(connectionA.ID(), "direct", "from b", "1"))
+			update := clientA.ExpectUpdate("direct", time.Second)
+			assert.JSONEq(t, `"from b"`, string(update.Data))
+			assert.Equal(t, "1", update.Extra)
+
+			assert.NoError(t, serverA.Broadcast("announcement", "hello"))
+			clientA.ExpectUpdate("announcement", time.Second)
+			clientB.ExpectUpdate("announcement", time.Second)
+			clientA.ExpectNoUpdate(time.Millisecond * 50)
+
+			clientB.SendUpdate(socketify.SubscribeUpdateType, socketify.TopicRequest{Topic: "orders"})
+			clientB.ExpectUpdate(socketify.SubscribedUpdateType, time.Second)
+
+			assert.Equal(t, 0, ordersA.Publish(order{Symbol: "BTC", Price: 1}))
+			clientB.ExpectUpdate("orders", time.Second)
+			assert.Equal(t, 1, ordersB.Subscribers())
+
+			assert.NoError(t, connectionB.Join("lobby"))
+			assert.NoError(t, serverA.BroadcastToRoom("lobby", "joined", "a", "2"))
+			update = clientB.ExpectUpdate("joined", time.Second)
+			assert.Equal(t, "2", update.Extra)
+			clientA.ExpectNoUpdate(time.Millisecond * 50)
+
+			// A connection that never reads holds its own updates up, not the broker's
+			stalled, _, err := pipeA.Dialer().Dial(pipeA.URL, nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer stalled.Close()
+
+			assert.Eventually(t, func() bool {
+				return len(serverA.Storage().ClientIDs()) == 2
+			}, time.Second, time.Millisecond*10)
+
+			for n := 1; n <= 3; n++ {
+				assert.NoError(t, serverB.Broadcast("tick", n))
+				update := clientA.ExpectUpdate("tick", time.Second)
+				assert.JSONEq(t, strconv.Itoa(n), string(update.Data))
+			}
+		})
+	}
+
+	t.Run("TCPConcurrentSubscriptions", func(t *testing.T) {
+		server, err := socketify.ListenTCPBroker("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = server.Close()
+		})
+
+		subscriber, err := socketify.DialTCPBroker(server.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		publisher, err := socketify.DialTCPBroker(server.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = subscriber.Close()
+			_ = publisher.Close()
+		})
+
+		// Every subscription must get the messages published after Subscribe returns, while others come and go
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				for n := 0; n < 10; n++ {
+					want := fmt.Sprintf("%d-%d", i, n)
+					received := make(chan struct{})
+					var once sync.Once
+
+					unsubscribe, err := subscriber.Subscribe("channel", func(message []byte) {
+						if string(message) == want {
+							once.Do(func() {
+								close(received)
+							})
+						}
+					})
+					if !assert.NoError(t, err) {
+						return
+					}
+
+					assert.NoError(t, publisher.Publish(context.Background(), "channel", []byte(want)))
+
+					select {
+					case <-received:
+					case <-time.After(time.Second):
+						t.Errorf("message %s missed", want)
+					}
+
+					unsubscribe()
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	t.Run("TCPStalledPeer", func(t *testing.T) {
+		server, err := socketify.ListenTCPBroker("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.SetWriteTimeout(time.Second)
+		t.Cleanup(func() {
+			_ = server.Close()
+		})
+
+		// A client subscribed to the channel that never reads its messages
+		stalled, err := net.Dial("tcp", server.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stalled.Close()
+
+		_, err = stalled.Write([]byte(`{"op":"sub","id":1,"channel":"channel"}` + "\n"))
+		assert.NoError(t, err)
+		ack, err := bufio.NewReader(stalled).ReadString('\n')
+		assert.NoError(t, err)
+		assert.Contains(t, ack, `"op":"ack"`)
+
+		subscriber, err := socketify.DialTCPBroker(server.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		publisher, err := socketify.DialTCPBroker(server.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = subscriber.Close()
+			_ = publisher.Close()
+		})
+
+		const messages = 200
+		received := make(chan struct{}, messages)
+		_, err = subscriber.Subscribe("channel", func([]byte) {
+			received <- struct{}{}
+		})
+		assert.NoError(t, err)
+
+		message := bytes.Repeat([]byte("x"), 64*1024)
+		for n := 0; n < messages; n++ {
+			assert.NoError(t, publisher.Publish(context.Background(), "channel", message))
+		}
+
+		for n := 0; n < messages; n++ {
+			select {
+			case <-received:
+			case <-time.After(time.Second * 5):
+				t.Fatalf("received %d messages out of %d", n, messages)
+			}
+		}
+	})
+
+	t.Run("QueueOverflow", func(t *testing.T) {
+		broker := socketify.NewMemoryBroker()
+		serverA := socketify.NewServer(socketify.ServerOptions().SetNodeID("a").SetBroker(broker).SetBrokerQueueSize(2).SetLogger(socketify.NopLogger()))
+		serverB := socketify.NewServer(socketify.ServerOptions().SetNodeID("b").SetBroker(broker).SetLogger(socketify.NopLogger()))
+
+		pipeA := socketifytest.NewPipeServer(t, serverA, nil)
+		_ = socketifytest.NewPipeServer(t, serverB, nil)
+
+		// The client never reads, the first update blocks its writer and the next ones wait in the queue
+		stalled, _, err := pipeA.Dialer().Dial(pipeA.URL, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer stalled.Close()
+
+		var connection *socketify.Connection
+		assert.Eventually(t, func() bool {
+			ids := serverA.Storage().ClientIDs()
+			if len(ids) != 1 {
+				return false
+			}
+			connection = serverA.Storage().GetClientByID(ids[0])
+			return connection != nil
+		}, time.Second, time.Millisecond*10)
+
+		for n := 0; n < 5; n++ {
+			assert.NoError(t, serverB.Broadcast("tick", n))
+		}
+
+		socketifytest.AssertConnectionClosedWith(t, connection, websocket.CloseTryAgainLater)
+	})
+
+	t.Run("NoBroker", func(t *testing.T) {
+		server := socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).EnableStorage())
+
+		assert.ErrorIs(t, server.SendToClient("unknown", "direct", nil), socketify.ErrClientNotFound)
+	})
+}
@@ -1,23 +1,26 @@
 package socketify
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"io"
 	"net/http"
 	"sync"
+	"time"
 )
 
 type Client struct {
 	*writer
 
 	address string
+	opts    *clientOptions
 
 	ws           *websocket.Conn
 	handlersLock sync.Mutex
 
-	handlers map[string]func(json.RawMessage)
+	handlers map[string]func(context.Context, json.RawMessage)
 
 	rawHandler func(message []byte)
 
@@ -25,45 +28,115 @@ type Client struct {
 
 	onClose func(err error)
 
+	onErrorReply func(err *Error, extra string)
+
 	rawMiddleware func(update []byte)
+
+	keepAlive  *keepAlive
+	dispatcher *dispatcher
+
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	closeOnce sync.Once
 }
 
 func NewClient(address string) (*Client, error) {
-	ch := make(chan messageType)
+	return NewClientContext(context.Background(), address, nil)
+}
 
-	cl := &Client{
-		address:  address,
-		handlers: map[string]func(json.RawMessage){},
-		writer:   newWriter(ch, logger{}),
+func NewClientWithOptions(address string, opts *clientOptions) (*Client, error) {
+	return NewClientContext(context.Background(), address, opts)
+}
+
+// NewClientContext dials address using ctx
+// Cancelling ctx aborts the dial, or closes the client if it's already connected
+func NewClientContext(ctx context.Context, address string, opts *clientOptions) (*Client, error) {
+	if opts == nil {
+		opts = defaultClientOptions()
 	}
 
-	conn, _, err := websocket.DefaultDialer.Dial(address, nil)
+	opts.fillDefaults()
+
+	conn, _, err := opts.dialer.DialContext(ctx, address, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	ch := make(chan messageType)
+
+	clientCtx, cancel := context.WithCancelCause(context.Background())
+
+	cl := &Client{
+		address:    address,
+		opts:       opts,
+		handlers:   map[string]func(context.Context, json.RawMessage){},
+		writer:     newWriter(ch, clientCtx.Done(), WithFields(opts.logger, "address", address)),
+		keepAlive:  &keepAlive{},
+		dispatcher: newDispatcher(*opts.dispatchMode),
+		ctx:        clientCtx,
+		cancel:     cancel,
+	}
+
 	cl.ws = conn
+	cl.writer.spanExporter = opts.spanExporter
+	cl.keepAlive.touch(opts.clock.Now())
+
+	conn.SetPingHandler(func(appData string) error {
+		cl.keepAlive.touch(opts.clock.Now())
+		return conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+	})
+	conn.SetPongHandler(func(appData string) error {
+		cl.keepAlive.pong(opts.clock.Now(), appData)
+		return nil
+	})
 
 	go cl.writer.processWriter(conn)
 
 	go cl.processUpdates()
 
+	if opts.keepAliveEnabled() {
+		go cl.runKeepAlive(opts.clock.NewTicker(opts.keepAliveTick()))
+	}
+
+	go cl.closeOnDone(ctx)
+
 	return cl, nil
 }
 
+// Context returns a context that is cancelled when the client is closed
+// context.Cause returns the same error passed to onClose: a *websocket.CloseError for Close(), the read error,
+// a *TimeoutError or the cause of the NewClientContext context
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
 func (c *Client) SetRawHandler(fn func(message []byte)) *Client {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
 	c.rawHandler = fn
 
 	return c
 }
 
 func (c *Client) SetRawMiddleware(fn func(message []byte)) *Client {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
 	c.rawMiddleware = fn
 
 	return c
 }
 
 func (c *Client) SetUpdateTypeHandler(updateType string, fn func(message json.RawMessage)) *Client {
+	return c.SetUpdateTypeHandlerContext(updateType, func(_ context.Context, message json.RawMessage) {
+		fn(message)
+	})
+}
+
+// SetUpdateTypeHandlerContext is like SetUpdateTypeHandler but fn also receives a context carrying the update's span
+// Pass it to WriteUpdateContext so the update written continues the trace of the one received
+func (c *Client) SetUpdateTypeHandlerContext(updateType string, fn func(ctx context.Context, message json.RawMessage)) *Client {
 	c.handlersLock.Lock()
 	defer c.handlersLock.Unlock()
 
@@ -73,12 +146,18 @@ func (c *Client) SetUpdateTypeHandler(updateType string, fn func(message json.Ra
 }
 
 func (c *Client) SetOnError(fn func(err error)) *Client {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
 	c.onErr = fn
 
 	return c
 }
 
 func (c *Client) SetOnClose(fn func(err error)) *Client {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
 	c.onClose = fn
 
 	return c
@@ -105,55 +184,124 @@ func (c *Client) Close(code int, message string) {
 }
 
 func (c *Client) close(code int, message string) {
-	defer c.ws.Close()
+	c.closeWithError(code, message, &websocket.CloseError{Code: code, Text: message})
+}
 
-	r, err := c.NextWriterCloseMessage()
-	if err == nil {
-		_, _ = r.Write(websocket.FormatCloseMessage(code, message))
-	}
+// closeWithError closes the connection once and passes cause to onClose
+func (c *Client) closeWithError(code int, message string, cause error) {
+	c.closeOnce.Do(func() {
+		defer c.ws.Close()
+
+		c.cancel(cause)
+
+		_ = c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, message), time.Now().Add(time.Second))
+
+		c.handlersLock.Lock()
+		onClose := c.onClose
+		c.handlersLock.Unlock()
+
+		if onClose != nil {
+			go onClose(cause)
+		}
+	})
+}
 
-	if c.onClose != nil {
-		go c.onClose(fmt.Errorf(message))
+func (c *Client) closeOnDone(parent context.Context) {
+	select {
+	case <-parent.Done():
+		c.closeWithError(websocket.CloseGoingAway, "context_done", context.Cause(parent))
+	case <-c.ctx.Done():
 	}
 }
 
 func (c *Client) handlerErr(err error) {
-	if c.onErr != nil {
-		c.onErr(err)
+	c.handlersLock.Lock()
+	onErr := c.onErr
+	c.handlersLock.Unlock()
+
+	if onErr != nil {
+		onErr(err)
 	}
 }
 
 func (c *Client) processUpdates() {
 	for {
-		_, message, err := c.ws.ReadMessage()
+		messageType, message, err := c.ws.ReadMessage()
 		if err != nil {
-			go c.handlerErr(err)
-			go c.close(http.StatusInternalServerError, err.Error())
+			select {
+			case <-c.ctx.Done():
+				// Closed locally, the read error is expected
+			default:
+				go c.handlerErr(err)
+				go c.closeWithError(http.StatusInternalServerError, err.Error(), err)
+			}
 			return
 		}
 
-		if c.rawMiddleware != nil {
-			go c.rawMiddleware(message)
-		}
+		c.keepAlive.touch(c.opts.clock.Now())
+		c.recordFrame(FrameDirectionIn, messageType, message)
 
-		if c.rawHandler != nil {
-			go c.rawHandler(message)
-			continue
-		}
+		c.handleMessage(message)
+	}
+}
 
-		var u *Update
+// handleMessage runs the raw middleware for every frame, including the ones that aren't valid updates,
+// then the raw handler or the handler of the update type
+func (c *Client) handleMessage(message []byte) {
+	c.handlersLock.Lock()
+	rawHandler, rawMiddleware := c.rawHandler, c.rawMiddleware
+	c.handlersLock.Unlock()
 
-		err = json.Unmarshal(message, &u)
-		if err != nil {
-			go c.handlerErr(err)
-			continue
-		}
+	var (
+		u         *Update
+		decodeErr error
+	)
 
-		c.handlersLock.Lock()
-		if handler, ok := c.handlers[u.Type]; ok {
-			go handler(u.Data)
-			continue
-		}
-		c.handlersLock.Unlock()
+	if rawHandler == nil {
+		decodeErr = json.Unmarshal(message, &u)
 	}
+
+	c.dispatcher.dispatch(u, func() {
+		c.safe(func() {
+			if rawMiddleware != nil {
+				rawMiddleware(message)
+			}
+
+			if rawHandler != nil {
+				rawHandler(message)
+				return
+			}
+
+			if decodeErr != nil {
+				c.handlerErr(decodeErr)
+				return
+			}
+
+			if u == nil {
+				return
+			}
+
+			c.handlersLock.Lock()
+			handler, ok := c.handlers[u.Type]
+			onErrorReply := c.onErrorReply
+			c.handlersLock.Unlock()
+
+			if ok {
+				ctx, span := c.traceUpdate(u)
+				defer span.End()
+
+				handler(ctx, u.Data)
+				return
+			}
+
+			if u.Type == c.opts.errorReplyType && onErrorReply != nil {
+				var e *Error
+				if err := json.Unmarshal(u.Data, &e); err != nil || e == nil {
+					c.handlerErr(fmt.Errorf("invalid error reply: %s", u.Data))
+					return
+				}
+				onErrorReply(e, u.Extra)
+			}
+		})
+	})
 }
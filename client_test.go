@@ -1,142 +1,370 @@
 package socketify_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewClient(t *testing.T) {
-	type args struct {
-		address string
-	}
 	tests := []struct {
 		name    string
-		args    args
-		wantErr bool
+		message string
 	}{
 		{
-			name: "Successful",
-			args: args{
-				address: "ws://127.0.0.1:8080/",
-			},
-			wantErr: false,
+			name:    "Successful",
+			message: "close",
 		},
 	}
 
-	go runServer()
-
-	// time.Sleep(time.Second * 5)
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ch := make(chan bool)
-
-			got, _ := socketify.NewClient(tt.args.address)
-
-			got.SetRawHandler(func(message []byte) {
-				fmt.Println("received", string(message), "and closing...")
-				got.Close(200, "close")
+			server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())), func(c *socketify.Connection) {
+				c.WriteUpdate("client_id", c.ID())
 			})
 
-			var receivedErr error
+			got, connection := server.Pair()
 
-			got.SetOnClose(func(err error) {
-				fmt.Println("received error", err)
-				receivedErr = err
-				ch <- true
-			})
+			update := got.ExpectUpdate("client_id", time.Second)
+			assert.JSONEq(t, fmt.Sprintf("%q", connection.ID()), string(update.Data))
 
-			<-ch
-			assert.ErrorContains(t, receivedErr, "close")
+			got.Close(websocket.CloseNormalClosure, tt.message)
+
+			socketifytest.AssertConnectionClosedWith(t, connection, websocket.CloseNormalClosure)
+			assert.ErrorContains(t, context.Cause(got.Context()), tt.message)
 		})
 	}
 }
 
 func TestNewClientWrite(t *testing.T) {
-	type args struct {
-		address string
-	}
 	tests := []struct {
 		name    string
-		args    args
-		wantErr bool
+		message string
 	}{
 		{
-			name: "Successful",
-			args: args{
-				address: "ws://127.0.0.1:8080/",
-			},
-			wantErr: false,
+			name:    "Successful",
+			message: "bye",
 		},
 	}
 
-	go runServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := socketifytest.NewServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())), func(c *socketify.Connection) {
+				c.HandleRawUpdate(func(message []byte) {
+					if string(message) == "Hello" {
+						c.WriteText("Hello")
+					}
+				})
+			})
+
+			got, connection := server.Pair()
+
+			assert.NoError(t, got.WriteText("Hello"))
+			assert.Equal(t, "Hello", string(got.ExpectRaw(time.Second)))
 
-	// time.Sleep(time.Second * 5)
+			got.Close(websocket.CloseNormalClosure, tt.message)
+
+			socketifytest.AssertConnectionClosedWith(t, connection, websocket.CloseNormalClosure)
+			assert.ErrorContains(t, context.Cause(got.Context()), tt.message)
+		})
+	}
+}
+
+func TestClientIdleTimeoutFakeClock(t *testing.T) {
+	clock := socketifytest.NewFakeClock(time.Now())
+
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())), nil)
+
+	got, connection := server.PairWith(func(url string, dialer *websocket.Dialer) (*socketify.Client, error) {
+		opts := socketify.ClientOptions().SetLogger(socketify.NopLogger()).SetDialer(dialer).SetClock(clock).SetIdleTimeout(time.Minute)
+		return socketify.NewClientWithOptions(url, opts)
+	})
+
+	clock.Advance(time.Second * 30)
+	got.ExpectNoUpdate(time.Millisecond * 50)
+	assert.NoError(t, got.Closed())
+
+	clock.Advance(time.Minute)
+
+	select {
+	case <-got.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("client was not closed")
+	}
+
+	var timeoutErr *socketify.TimeoutError
+	assert.ErrorAs(t, context.Cause(got.Context()), &timeoutErr)
+	assert.Equal(t, socketify.TimeoutReasonIdle, timeoutErr.Reason)
+
+	socketifytest.AssertConnectionClosedWith(t, connection, websocket.CloseGoingAway)
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverReads bool
+		wantTimeout bool
+	}{
+		{
+			name:        "MeasuresLatency",
+			serverReads: true,
+			wantTimeout: false,
+		},
+		{
+			name:        "PongTimeout",
+			serverReads: false,
+			wantTimeout: true,
+		},
+	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := socketify.NewClient(tt.args.address)
+			address := runRawServer(t, tt.serverReads)
+
+			opts := socketify.ClientOptions().SetLogger(socketify.NopLogger()).SetKeepAlive(time.Millisecond*20, time.Millisecond*60)
+
+			got, err := socketify.NewClientWithOptions(address, opts)
 			if err != nil {
-				panic(err)
+				t.Fatal(err)
+			}
+
+			closed := make(chan error, 1)
+			got.SetOnClose(func(err error) {
+				closed <- err
+			})
+
+			select {
+			case err := <-closed:
+				var timeoutErr *socketify.TimeoutError
+				assert.True(t, tt.wantTimeout, "unexpected close: %v", err)
+				assert.ErrorAs(t, err, &timeoutErr)
+				assert.Equal(t, socketify.TimeoutReasonPong, timeoutErr.Reason)
+			case <-time.After(time.Millisecond * 300):
+				assert.False(t, tt.wantTimeout, "client was not closed")
+				assert.Greater(t, got.Latency(), time.Duration(0))
+				got.Close(websocket.CloseNormalClosure, "done")
+			}
+		})
+	}
+}
+
+// runRawServer starts a plain websocket server, it doesn't answer pings unless reads is true
+func runRawServer(t *testing.T, reads bool) string {
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if !reads {
+			<-r.Context().Done()
+			return
+		}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
 			}
-			var wait = make(chan bool)
+		}
+	}))
+	t.Cleanup(server.Close)
 
-			var receivedErr error
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestClientContext(t *testing.T) {
+	errShutdown := errors.New("shutdown")
 
-			got.SetRawHandler(func(message []byte) {
-				if string(message) == "Hello" {
-					fmt.Println("received", string(message), "and closing...")
-					got.Close(200, "bye")
-				} else {
-					fmt.Println("received", string(message))
+	tests := []struct {
+		name       string
+		close      func(client *socketify.Client, connection *socketify.Connection, cancel context.CancelCauseFunc)
+		wantCause  func(t *testing.T, cause error)
+		serverCode int
+	}{
+		{
+			name: "Close",
+			close: func(client *socketify.Client, _ *socketify.Connection, _ context.CancelCauseFunc) {
+				client.Close(websocket.CloseNormalClosure, "bye")
+			},
+			wantCause: func(t *testing.T, cause error) {
+				var closeErr *websocket.CloseError
+				if assert.ErrorAs(t, cause, &closeErr) {
+					assert.Equal(t, websocket.CloseNormalClosure, closeErr.Code)
+					assert.Equal(t, "bye", closeErr.Text)
 				}
-			})
+			},
+			serverCode: websocket.CloseNormalClosure,
+		},
+		{
+			name: "ClosedByServer",
+			close: func(_ *socketify.Client, connection *socketify.Connection, _ context.CancelCauseFunc) {
+				_ = connection.CloseWithCode(websocket.ClosePolicyViolation, "kicked")
+			},
+			wantCause: func(t *testing.T, cause error) {
+				var closeErr *websocket.CloseError
+				if assert.ErrorAs(t, cause, &closeErr) {
+					assert.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+					assert.Equal(t, "kicked", closeErr.Text)
+				}
+			},
+		},
+		{
+			name: "ParentCancelled",
+			close: func(_ *socketify.Client, _ *socketify.Connection, cancel context.CancelCauseFunc) {
+				cancel(errShutdown)
+			},
+			wantCause: func(t *testing.T, cause error) {
+				assert.ErrorIs(t, cause, errShutdown)
+			},
+			serverCode: websocket.CloseGoingAway,
+		},
+	}
 
-			got.SetOnClose(func(err error) {
-				receivedErr = err
-				wait <- true
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())), nil)
+
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+
+			got, connection := server.PairWith(func(url string, dialer *websocket.Dialer) (*socketify.Client, error) {
+				return socketify.NewClientContext(ctx, url, socketify.ClientOptions().SetLogger(socketify.NopLogger()).SetDialer(dialer))
 			})
 
-			go got.WriteText("Hello")
-			fmt.Println("waiting")
-			<-wait
-			assert.ErrorContains(t, receivedErr, "bye")
+			tt.close(got.Client, connection, cancel)
+
+			select {
+			case <-got.Context().Done():
+			case <-time.After(time.Second):
+				t.Fatal("client context was not cancelled")
+			}
+
+			tt.wantCause(t, context.Cause(got.Context()))
+
+			assert.Eventually(t, func() bool {
+				return got.Closed() == context.Cause(got.Context())
+			}, time.Second, time.Millisecond*10, "onClose was not called with the cause")
+
+			if tt.serverCode != 0 {
+				socketifytest.AssertConnectionClosedWith(t, connection, tt.serverCode)
+			}
 		})
 	}
 }
 
-func runServer() {
-	s := socketify.NewServer(socketify.ServerOptions().SetAddress(":8080").SetEndpoint("/"))
+func TestNewClientContextCancelledDial(t *testing.T) {
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	got, err := socketify.NewClientContext(ctx, server.URL, socketify.ClientOptions().SetLogger(socketify.NopLogger()).SetDialer(server.Dialer()))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, got)
+}
+
+func TestConnectionProcessUpdatesContext(t *testing.T) {
+	errShutdown := errors.New("shutdown")
+
+	server := socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()))
+	hs := httptest.NewServer(server)
+	t.Cleanup(hs.Close)
 
+	connections := make(chan *socketify.Connection, 1)
 	go func() {
-		err := s.Listen()
-		if err != nil {
-			panic(err)
+		connection, err := (<-server.UpgradeRequests()).Upgrade()
+		if err == nil {
+			connections <- connection
 		}
 	}()
 
-	for upgradeRequest := range s.UpgradeRequests() {
-		client, err := upgradeRequest.Upgrade()
-		if err != nil {
-			panic(err)
+	got, err := socketify.NewClient("ws" + strings.TrimPrefix(hs.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var connection *socketify.Connection
+	select {
+	case connection = <-connections:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not upgraded")
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	processed := make(chan error, 1)
+	go func() {
+		processed <- connection.ProcessUpdatesContext(ctx)
+	}()
+
+	cancel(errShutdown)
+
+	select {
+	case err := <-processed:
+		assert.ErrorIs(t, err, errShutdown)
+	case <-time.After(time.Second):
+		t.Fatal("ProcessUpdatesContext did not return")
+	}
+
+	assert.ErrorIs(t, context.Cause(connection.Context()), errShutdown)
+
+	select {
+	case <-got.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("client was not closed")
+	}
+}
+
+func TestClientRawMiddleware(t *testing.T) {
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())), nil)
+
+	// The frames have different update types, they're only handled in order sequentially
+	got, connection := server.PairWith(func(url string, dialer *websocket.Dialer) (*socketify.Client, error) {
+		return socketify.NewClientWithOptions(url, socketify.ClientOptions().SetLogger(socketify.NopLogger()).SetDialer(dialer).SetDispatchMode(socketify.DispatchSequential()))
+	})
+
+	frames := make(chan string, 2)
+	errs := make(chan error, 1)
+
+	// Replaces the harness' raw handler so updates reach the update type handlers
+	got.SetRawHandler(nil)
+	got.SetRawMiddleware(func(message []byte) {
+		frames <- string(message)
+	})
+	got.SetOnError(func(err error) {
+		errs <- err
+	})
+
+	assert.NoError(t, connection.WriteText("not json"))
+	assert.NoError(t, connection.WriteUpdate("ping", nil))
+
+	for _, want := range []string{"not json", `{"type":"ping"}`} {
+		select {
+		case frame := <-frames:
+			assert.Equal(t, want, frame)
+		case <-time.After(time.Second):
+			t.Fatalf("middleware didn't see %s", want)
 		}
-		client.HandleRawUpdate(func(message []byte) {
-			fmt.Println(string(message))
-			if string(message) == "Hello" {
-				client.WriteText("Hello")
-			}
-		})
-		go func(connection *socketify.Connection) {
-			connection.WriteUpdate("client_id", connection.ID())
-			err := connection.ProcessUpdates()
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
+	}
 
-		}(client)
+	select {
+	case err := <-errs:
+		var syntaxErr *json.SyntaxError
+		assert.ErrorAs(t, err, &syntaxErr)
+	case <-time.After(time.Second):
+		t.Fatal("decode error was not reported")
 	}
 }
@@ -1,15 +1,170 @@
 package socketify
 
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ErrorCategory string
+
+const (
+	ErrorCategoryRead       ErrorCategory = "read"
+	ErrorCategoryDecode     ErrorCategory = "decode"
+	ErrorCategoryMiddleware ErrorCategory = "middleware"
+	ErrorCategoryHandler    ErrorCategory = "handler"
+	ErrorCategoryValidation ErrorCategory = "validation"
+	ErrorCategoryPanic      ErrorCategory = "panic"
+	ErrorCategoryRateLimit  ErrorCategory = "rate_limit"
+	ErrorCategorySizeLimit  ErrorCategory = "size_limit"
+)
+
 type UpdateError struct {
 	Update []byte
 	Error  error
 	Extra  []string
+	// UpdateType is empty when the update couldn't be decoded or was handled by a raw handler
+	UpdateType string
+	Category   ErrorCategory
+	Time       time.Time
+	// Stack is set when Error is a *PanicError
+	Stack []byte
+}
+
+func newUpdateError(category ErrorCategory, message []byte, update *Update, err error) UpdateError {
+	ue := UpdateError{
+		Update:   message,
+		Error:    err,
+		Category: category,
+		Time:     time.Now(),
+	}
+
+	if update != nil {
+		ue.UpdateType = update.Type
+		if update.Extra != "" {
+			ue.Extra = []string{update.Extra}
+		}
+	}
+
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		ue.Stack = panicErr.Stack
+	}
+
+	return ue
+}
+
+type ErrorOverflowPolicy int
+
+const (
+	// ErrorOverflowDropNewest drops new errors while the buffer is full, it's the default so Errors() is lossy by default
+	ErrorOverflowDropNewest ErrorOverflowPolicy = iota
+	// ErrorOverflowDropOldest drops the oldest buffered error to make room for the new one
+	ErrorOverflowDropOldest
+	// ErrorOverflowBlock blocks the reporting goroutine (and so reading from the socket) until the error is received
+	// The read error closing the connection never blocks, it's dropped if the buffer is full
+	ErrorOverflowBlock
+)
+
+const defaultErrorBufferSize = 64
+
+// errorStream is the buffered channel behind Connection.Errors()
+// Senders hold the read lock so close can't happen while they send, blocked senders give up once done is closed
+type errorStream struct {
+	m       sync.RWMutex
+	ch      chan UpdateError
+	closed  bool
+	policy  ErrorOverflowPolicy
+	done    <-chan struct{}
+	dropped uint64
+
+	onErrorLock sync.Mutex
+	onError     func(UpdateError)
+}
+
+func newErrorStream(size int, policy ErrorOverflowPolicy, done <-chan struct{}) *errorStream {
+	return &errorStream{
+		ch:     make(chan UpdateError, size),
+		policy: policy,
+		done:   done,
+	}
 }
 
-func newUpdateError(update []byte, err error, extra ...string) UpdateError {
-	return UpdateError{
-		Update: update,
-		Error:  err,
-		Extra:  extra,
+func (s *errorStream) setOnError(fn func(UpdateError)) {
+	s.onErrorLock.Lock()
+	defer s.onErrorLock.Unlock()
+
+	s.onError = fn
+}
+
+func (s *errorStream) report(ue UpdateError) {
+	s.send(ue, s.policy)
+}
+
+// reportWithoutBlocking is like report but drops the error instead of blocking with ErrorOverflowBlock
+// It's used for the read error, the connection only closes once the reading goroutine returns
+func (s *errorStream) reportWithoutBlocking(ue UpdateError) {
+	policy := s.policy
+	if policy == ErrorOverflowBlock {
+		policy = ErrorOverflowDropNewest
+	}
+
+	s.send(ue, policy)
+}
+
+func (s *errorStream) send(ue UpdateError, policy ErrorOverflowPolicy) {
+	s.onErrorLock.Lock()
+	onError := s.onError
+	s.onErrorLock.Unlock()
+
+	if onError != nil {
+		onError(ue)
+	}
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	if s.closed {
+		return
+	}
+
+	switch policy {
+	case ErrorOverflowBlock:
+		select {
+		case s.ch <- ue:
+		case <-s.done:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	case ErrorOverflowDropOldest:
+		for {
+			select {
+			case s.ch <- ue:
+				return
+			default:
+			}
+
+			select {
+			case <-s.ch:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- ue:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+func (s *errorStream) close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
 	}
 }
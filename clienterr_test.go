@@ -0,0 +1,176 @@
+package socketify
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testUpdateError(n int) UpdateError {
+	return newUpdateError(ErrorCategoryHandler, nil, &Update{Type: fmt.Sprint(n)}, errors.New("failed"))
+}
+
+func drainErrors(s *errorStream) []string {
+	var types []string
+	for {
+		select {
+		case ue := <-s.ch:
+			types = append(types, ue.UpdateType)
+		default:
+			return types
+		}
+	}
+}
+
+func TestErrorStreamOverflow(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      ErrorOverflowPolicy
+		want        []string
+		wantDropped uint64
+	}{
+		{
+			name:        "DropNewest",
+			policy:      ErrorOverflowDropNewest,
+			want:        []string{"1", "2"},
+			wantDropped: 1,
+		},
+		{
+			name:        "DropOldest",
+			policy:      ErrorOverflowDropOldest,
+			want:        []string{"2", "3"},
+			wantDropped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newErrorStream(2, tt.policy, make(chan struct{}))
+
+			var called int
+			s.setOnError(func(UpdateError) {
+				called++
+			})
+
+			for n := 1; n <= 3; n++ {
+				s.report(testUpdateError(n))
+			}
+
+			assert.Equal(t, tt.want, drainErrors(s))
+			assert.Equal(t, tt.wantDropped, atomic.LoadUint64(&s.dropped))
+			assert.Equal(t, 3, called, "OnError must see dropped errors too")
+		})
+	}
+}
+
+func TestErrorStreamBlock(t *testing.T) {
+	s := newErrorStream(1, ErrorOverflowBlock, make(chan struct{}))
+
+	s.report(testUpdateError(1))
+
+	reported := make(chan struct{})
+	go func() {
+		s.report(testUpdateError(2))
+		close(reported)
+	}()
+
+	select {
+	case <-reported:
+		t.Fatal("report didn't block on a full buffer")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	assert.Equal(t, "1", (<-s.ch).UpdateType)
+
+	select {
+	case <-reported:
+	case <-time.After(time.Second):
+		t.Fatal("report stayed blocked after the buffer was read")
+	}
+
+	assert.Equal(t, []string{"2"}, drainErrors(s))
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&s.dropped))
+}
+
+func TestErrorStreamCloseWithBlockedSenders(t *testing.T) {
+	done := make(chan struct{})
+	s := newErrorStream(1, ErrorOverflowBlock, done)
+
+	s.report(testUpdateError(0))
+
+	var wg sync.WaitGroup
+	for n := 1; n <= 3; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			s.report(testUpdateError(n))
+		}(n)
+	}
+
+	// Lets the senders block, closing the connection cancels done before closing the stream like closeWithCause
+	time.Sleep(time.Millisecond * 50)
+	close(done)
+	s.close()
+	wg.Wait()
+
+	var types []string
+	for ue := range s.ch {
+		types = append(types, ue.UpdateType)
+	}
+
+	assert.Equal(t, []string{"0"}, types)
+	assert.Equal(t, uint64(3), atomic.LoadUint64(&s.dropped))
+
+	assert.NotPanics(t, func() {
+		s.report(testUpdateError(4))
+	})
+}
+
+func TestErrorStreamConcurrent(t *testing.T) {
+	policies := map[string]ErrorOverflowPolicy{
+		"DropNewest": ErrorOverflowDropNewest,
+		"DropOldest": ErrorOverflowDropOldest,
+		"Block":      ErrorOverflowBlock,
+	}
+
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			done := make(chan struct{})
+			s := newErrorStream(4, policy, done)
+
+			var received int64
+			read := make(chan struct{})
+			go func() {
+				defer close(read)
+				for range s.ch {
+					atomic.AddInt64(&received, 1)
+				}
+			}()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for n := 0; n < 100; n++ {
+						s.report(testUpdateError(i*100 + n))
+					}
+				}(i)
+			}
+
+			wg.Wait()
+			close(done)
+			s.close()
+			<-read
+
+			assert.Equal(t, int64(800), atomic.LoadInt64(&received)+int64(atomic.LoadUint64(&s.dropped)))
+			if policy == ErrorOverflowBlock {
+				assert.Equal(t, int64(800), atomic.LoadInt64(&received))
+			}
+		})
+	}
+}
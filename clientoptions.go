@@ -0,0 +1,107 @@
+package socketify
+
+import (
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+type clientOptions struct {
+	logger       Logger
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	idleTimeout  time.Duration
+	dispatchMode *DispatchMode
+	spanExporter SpanExporter
+	dialer       *websocket.Dialer
+	clock        Clock
+
+	errorReplyType string
+}
+
+func defaultClientOptions() *clientOptions {
+	return &clientOptions{
+		logger: defaultLogger(),
+	}
+}
+
+func ClientOptions() *clientOptions {
+	return &clientOptions{}
+}
+
+// SetDispatchMode sets how handlers are run, the default is DispatchKeyed(0, KeyByUpdateType) so updates of a type
+// are handled in the order they're received
+func (o *clientOptions) SetDispatchMode(mode DispatchMode) *clientOptions {
+	o.dispatchMode = &mode
+	return o
+}
+
+// SetErrorReplyType sets the update type of error replies passed to Client.SetOnErrorReply, the default is "error"
+func (o *clientOptions) SetErrorReplyType(updateType string) *clientOptions {
+	o.errorReplyType = updateType
+	return o
+}
+
+// SetSpanExporter enables tracing, a span is started for every WriteUpdate and every update passed to a handler
+// Updates carry their span's context in the "traceparent" field so the server continues the trace
+func (o *clientOptions) SetSpanExporter(exporter SpanExporter) *clientOptions {
+	o.spanExporter = exporter
+	return o
+}
+
+// SetDialer sets the dialer used to connect, the default is websocket.DefaultDialer
+func (o *clientOptions) SetDialer(dialer *websocket.Dialer) *clientOptions {
+	o.dialer = dialer
+	return o
+}
+
+// SetClock sets the time source of keepalive checks, it's meant for tests
+func (o *clientOptions) SetClock(clock Clock) *clientOptions {
+	o.clock = clock
+	return o
+}
+
+func (o *clientOptions) SetLogger(l Logger) *clientOptions {
+	o.logger = l
+	return o
+}
+
+// SetKeepAlive makes the client ping the server every interval
+// If a pong is not received within pongTimeout the connection is considered dead and closed with a *TimeoutError
+// pongTimeout defaults to interval when zero
+func (o *clientOptions) SetKeepAlive(interval, pongTimeout time.Duration) *clientOptions {
+	o.pingInterval = interval
+	o.pongTimeout = pongTimeout
+	return o
+}
+
+// SetIdleTimeout closes the connection with a *TimeoutError if nothing (messages, pings or pongs) is received for d
+func (o *clientOptions) SetIdleTimeout(d time.Duration) *clientOptions {
+	o.idleTimeout = d
+	return o
+}
+
+func (o *clientOptions) keepAliveEnabled() bool {
+	return o.pingInterval > 0 || o.idleTimeout > 0
+}
+
+func (o *clientOptions) fillDefaults() {
+	if o.logger == nil {
+		o.logger = defaultLogger()
+	}
+	if o.dialer == nil {
+		o.dialer = websocket.DefaultDialer
+	}
+	if o.clock == nil {
+		o.clock = realClock{}
+	}
+	if o.errorReplyType == "" {
+		o.errorReplyType = defaultErrorReplyType
+	}
+	if o.dispatchMode == nil {
+		mode := DispatchKeyed(0, KeyByUpdateType)
+		o.dispatchMode = &mode
+	}
+	if o.pingInterval > 0 && o.pongTimeout <= 0 {
+		o.pongTimeout = o.pingInterval
+	}
+}
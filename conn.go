@@ -0,0 +1,47 @@
+package socketify
+
+import "context"
+
+// Conn is what handlers usually need from a connection, *Connection implements it
+// Depend on Conn instead of *Connection to unit test handlers with socketifytest.FakeConn
+type Conn interface {
+	ID() string
+	Context() context.Context
+	Logger() Logger
+
+	WriteUpdate(updateType string, data interface{}, extra ...string) error
+	WriteUpdateContext(ctx context.Context, updateType string, data interface{}, extra ...string) error
+	WriteRawUpdate(data interface{}) error
+	WriteBinaryBytes(data []byte) error
+	WriteBinaryText(data []byte) error
+	WriteText(data string) error
+
+	SetAttribute(key string, val interface{})
+	GetAttribute(key string) (val interface{}, exists bool)
+	OnAttributeChange(fn func(change AttributeChange))
+
+	Close() error
+	CloseWithCode(code int, text string) error
+}
+
+var _ Conn = (*Connection)(nil)
+
+type connContextKey struct{}
+
+// ContextWithConn returns a copy of ctx carrying c, for ConnFromContext
+func ContextWithConn(ctx context.Context, c Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// ConnFromContext returns the Conn set by ContextWithConn, or else the connection an update was received on
+func ConnFromContext(ctx context.Context) (Conn, bool) {
+	if c, ok := ctx.Value(connContextKey{}).(Conn); ok {
+		return c, true
+	}
+
+	if c, ok := ConnectionFromContext(ctx); ok {
+		return c, true
+	}
+
+	return nil, false
+}
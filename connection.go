@@ -1,52 +1,113 @@
 package socketify
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Connection struct {
 	*writer
 
-	id                  string
-	server              *Server
-	ws                  *websocket.Conn
-	internalUpdates     chan []byte
-	handlers            map[string]mapper
-	rawHandler          func(message []byte)
-	handlersLocker      sync.Mutex
-	closed              chan bool
-	attributes          map[string]interface{}
-	attributesLocker    sync.Mutex
-	onClose             func()
-	keepAlive           time.Duration
-	middleware          func(message []byte) error
-	middlewareForUpdate func(updateType string, data json.RawMessage) error
-	clientErrors        chan UpdateError
-	encryptionFields    *encryptionFields
-}
-
-func newConnection(server *Server, ws *websocket.Conn, clientID string, encryptionFields *encryptionFields) (c *Connection) {
+	id                    string
+	server                *Server
+	ws                    *websocket.Conn
+	internalUpdates       chan []byte
+	handlers              map[string]mapper
+	rawHandler            func(message []byte)
+	handlersLocker        sync.Mutex
+	ctx                   context.Context
+	cancel                context.CancelCauseFunc
+	closeOnce             sync.Once
+	attributes            map[string]interface{}
+	attributesLocker      sync.Mutex
+	attributeHooks        []func(change AttributeChange)
+	indexed               bool
+	onClose               func()
+	keepAlive             time.Duration
+	middleware            func(message []byte) error
+	middlewareForUpdate   func(updateType string, data json.RawMessage) error
+	middlewares           []Middleware
+	updateTypeMiddlewares map[string][]Middleware
+	chain                 UpdateHandler
+	updateTypeChains      map[string]UpdateHandler
+	clientErrors          *errorStream
+	encryptionFields      *encryptionFields
+	dispatcher            *dispatcher
+	rateLimiter           *connectionRateLimiter
+	release               func()
+	brokerM               sync.Mutex
+	brokerUnsubscribe     func()
+	brokerQueue           *brokerQueue
+	connectedAt           time.Time
+	lastActivity          int64
+}
+
+var (
+	ErrConnectionClosed = errors.New("connection_closed")
+	ErrUpdateTooLarge   = errors.New("update_too_large")
+)
+
+// newConnection starts the connection's writer, attributes are copied before so they're never written concurrently
+func newConnection(server *Server, ws *websocket.Conn, clientID string, encryptionFields *encryptionFields, attributes map[string]interface{}) (c *Connection) {
 	wr := make(chan messageType)
 
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	logger := WithFields(server.opts.logger, "connection_id", clientID, "remote_addr", ws.RemoteAddr().String())
+
 	c = &Connection{
-		id:               clientID,
-		server:           server,
-		ws:               ws,
-		writer:           newWriter(wr, server.opts.logger),
-		handlers:         map[string]mapper{},
-		closed:           make(chan bool),
-		attributes:       map[string]interface{}{},
-		internalUpdates:  make(chan []byte),
-		clientErrors:     make(chan UpdateError),
-		encryptionFields: encryptionFields,
+		id:                    clientID,
+		server:                server,
+		ws:                    ws,
+		writer:                newWriter(wr, ctx.Done(), logger),
+		handlers:              map[string]mapper{},
+		updateTypeMiddlewares: map[string][]Middleware{},
+		cancel:                cancel,
+		attributes:            map[string]interface{}{},
+		internalUpdates:       make(chan []byte),
+		encryptionFields:      encryptionFields,
+		dispatcher:            newDispatcher(server.opts.dispatchMode),
+		rateLimiter:           newConnectionRateLimiter(server.opts),
+		connectedAt:           time.Now(),
+	}
+
+	for key, val := range attributes {
+		c.attributes[key] = val
 	}
 
+	c.touch(c.connectedAt)
+	c.buildChains()
+
+	c.ctx = context.WithValue(ctx, connectionContextKey{}, c)
+	c.clientErrors = newErrorStream(server.opts.errorBufferSize, server.opts.errorOverflowPolicy, ctx.Done())
+
+	if server.opts.maxMessageSize > 0 {
+		ws.SetReadLimit(server.opts.maxMessageSize)
+	}
+
+	c.writer.connection = c
+	c.writer.metrics = server.metrics
+	c.writer.spanExporter = server.opts.spanExporter
+	c.writer.interceptors = append([]OutgoingInterceptor(nil), server.opts.outgoingInterceptors...)
+
+	if server.topics != nil {
+		c.handlers[SubscribeUpdateType] = DataMapperContext[TopicRequest](c.handleSubscribe)
+		c.handlers[UnsubscribeUpdateType] = DataMapperContext[TopicRequest](c.handleUnsubscribe)
+	}
+
+	if server.opts.broker != nil {
+		c.brokerQueue = newBrokerQueue(server.opts.brokerQueueSize, ctx.Done())
+	}
+
+	server.metrics.connectionOpened()
+
 	go c.processWriter(ws)
 
 	return
@@ -56,10 +117,14 @@ func (c *Connection) SetKeepAliveDuration(keepAlive time.Duration) {
 	c.keepAlive = keepAlive
 }
 
+// SetMiddleware sets a single middleware that can veto raw messages before they're decoded
+// Setting it again replaces the previous one, use Use and UseForUpdate to build middleware chains
 func (c *Connection) SetMiddleware(middleware func(message []byte) error) {
 	c.middleware = middleware
 }
 
+// SetUpdateTypeMiddleware sets a single middleware that can veto decoded updates
+// Setting it again replaces the previous one, use Use and UseForUpdate to build middleware chains
 func (c *Connection) SetUpdateTypeMiddleware(middleware func(updateType string, data json.RawMessage) error) {
 	c.middlewareForUpdate = middleware
 }
@@ -68,15 +133,35 @@ func (c *Connection) ID() string {
 	return c.id
 }
 
+// Logger returns the server's logger with the connection's id and remote address attached to every line
+func (c *Connection) Logger() Logger {
+	return c.logger
+}
+
 func (c *Connection) SetOnClose(onClose func()) {
 	c.onClose = onClose
 }
 
+// SetAttribute sets an attribute, see MustNewKey for typed keys
 func (c *Connection) SetAttribute(key string, val interface{}) {
 	c.attributesLocker.Lock()
-	defer c.attributesLocker.Unlock()
 
+	old, existed := c.attributes[key]
 	c.attributes[key] = val
+
+	if c.indexed {
+		if existed {
+			c.server.storage.unindex(c, key, old)
+		}
+		c.server.storage.index(c, key, val)
+	}
+
+	hooks := c.attributeHooks
+	c.attributesLocker.Unlock()
+
+	for _, hook := range hooks {
+		hook(AttributeChange{Key: key, Old: old, New: val, Existed: existed})
+	}
 }
 
 func (c *Connection) GetAttribute(key string) (val interface{}, exists bool) {
@@ -87,21 +172,49 @@ func (c *Connection) GetAttribute(key string) (val interface{}, exists bool) {
 	return
 }
 
+// Errors returns the buffered stream of errors of this connection, it's closed when the connection closes
+// It's lossy by default: once the buffer is full errors are dropped according to the server's ErrorOverflowPolicy
+// and counted by DroppedErrors. Use OnError to see every error, or ErrorOverflowBlock to never drop one
 func (c *Connection) Errors() <-chan UpdateError {
-	return c.clientErrors
+	return c.clientErrors.ch
+}
+
+// OnError registers a callback called for every error, before it's sent to Errors(), it never misses one
+// It's called on the goroutine that hit the error, so it should return quickly
+func (c *Connection) OnError(fn func(UpdateError)) {
+	c.clientErrors.setOnError(fn)
+}
+
+// DroppedErrors returns the number of errors dropped because the Errors() buffer was full
+func (c *Connection) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&c.clientErrors.dropped)
+}
+
+// Context returns a context that is cancelled when the connection is closed
+// context.Cause reports why: ErrConnectionClosed for Close(), the read error or the cause of the ProcessUpdatesContext context
+func (c *Connection) Context() context.Context {
+	return c.ctx
 }
 
 func (c *Connection) ProcessUpdates() error {
-	errChan := make(chan error)
+	return c.ProcessUpdatesContext(context.Background())
+}
+
+// ProcessUpdatesContext is like ProcessUpdates but also closes the connection once ctx is done
+func (c *Connection) ProcessUpdatesContext(ctx context.Context) error {
+	errChan := make(chan error, 1)
 
 	go c.handleIncomingUpdates(errChan)
 
 	select {
 	case err := <-errChan:
-		go c.close()
+		c.closeWithCause(err)
 		return err
-	case <-c.closed:
-		return errors.New("connection_closed")
+	case <-ctx.Done():
+		c.closeWithCause(context.Cause(ctx))
+		return context.Cause(ctx)
+	case <-c.ctx.Done():
+		return context.Cause(c.ctx)
 	}
 }
 
@@ -151,23 +264,44 @@ func (c *Connection) Close() error {
 	return c.close()
 }
 
-func (c *Connection) reportError(update []byte, err error, extra ...string) {
-	go func() {
-		c.clientErrors <- newUpdateError(update, err, extra...)
-	}()
+// CloseWithCode sends a close message with code and text to the client before closing the connection
+// The connection's context is cancelled with a *websocket.CloseError
+func (c *Connection) CloseWithCode(code int, text string) error {
+	_ = c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+
+	return c.closeWithCause(&websocket.CloseError{Code: code, Text: text})
+}
+
+func (c *Connection) touch(now time.Time) {
+	atomic.StoreInt64(&c.lastActivity, now.UnixNano())
+}
+
+// LastActivity returns when the last message was received from the client, or the connection time if none was
+func (c *Connection) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActivity))
+}
+
+// ConnectedAt returns when the connection was upgraded
+func (c *Connection) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+func (c *Connection) reportError(category ErrorCategory, message []byte, update *Update, err error) {
+	c.clientErrors.report(newUpdateError(category, message, update, err))
 }
 
 func (c *Connection) handleIncomingUpdates(errChannel chan error) {
 	var (
-		message []byte
-		err     error
+		messageType int
+		message     []byte
+		err         error
 	)
 
 	if c.keepAlive > 0 {
 		c.ws.SetReadDeadline(time.Now().Add(c.keepAlive))
 		c.ws.SetPingHandler(func(d string) error {
 			c.ws.SetReadDeadline(time.Now().Add(c.keepAlive))
-			return c.ws.WriteMessage(websocket.PongMessage, nil)
+			return c.ws.WriteControl(websocket.PongMessage, []byte(d), time.Now().Add(c.keepAlive))
 		})
 		c.ws.SetPongHandler(func(d string) error {
 			return c.ws.SetReadDeadline(time.Now().Add(c.keepAlive))
@@ -176,60 +310,151 @@ func (c *Connection) handleIncomingUpdates(errChannel chan error) {
 	}
 
 	for {
-		_, message, err = c.ws.ReadMessage()
+		messageType, message, err = c.ws.ReadMessage()
 		if err != nil {
-			c.server.opts.logger.Error(fmt.Sprintf("Error Reading Message: %s. RemoteAddr: %s", err, c.ws.RemoteAddr().String()))
+			if c.ctx.Err() != nil {
+				// Closed locally, the read error is expected
+				errChannel <- context.Cause(c.ctx)
+				return
+			}
+
+			c.logger.Error("Error reading message", "error", err)
+			c.clientErrors.reportWithoutBlocking(newUpdateError(ErrorCategoryRead, message, nil, err))
 			errChannel <- err
-			c.reportError(message, err)
 			return
 		}
 
-		if c.middleware != nil {
-			if err = c.middleware(message); err != nil {
-				c.server.opts.logger.Error(fmt.Sprintf("Error From Middleware: %s. RemoteAddr: %s", err, c.ws.RemoteAddr().String()))
-				c.reportError(message, err)
-				continue
-			}
+		c.touch(time.Now())
+		c.recordFrame(FrameDirectionIn, messageType, message)
+		c.handleMessage(message, false)
+	}
+}
+
+// handleMessage decodes a message read from the socket and dispatches it
+// The update is decoded on the reading goroutine so the dispatcher can key it
+// Replayed messages skip the rate and size limits, they were checked when the messages were recorded
+func (c *Connection) handleMessage(message []byte, replayed bool) {
+	var (
+		update    *Update
+		decodeErr error
+		decoded   bool
+	)
+
+	now := c.server.opts.clock.Now()
+
+	if !replayed && !c.allowMessage(now) {
+		c.server.metrics.messageReceived("", len(message))
+		c.rateLimited(message, nil)
+		return
+	}
+
+	if c.getRawHandler() == nil {
+		decodeErr = json.Unmarshal(message, &update)
+		decoded = true
+	}
+
+	if update == nil {
+		// Raw handlers and undecodable messages
+		c.server.metrics.messageReceived("", len(message))
+	} else {
+		c.server.metrics.messageReceived(update.Type, len(message))
+
+		if !replayed && !c.allowUpdate(now, update.Type) {
+			c.rateLimited(message, update)
+			return
 		}
 
-		if rawHandler := c.getRawHandler(); rawHandler != nil {
-			rawHandler(message)
-			continue
+		if !replayed && c.tooLarge(update) {
+			c.logger.Warn("Update data too large", "update_type", update.Type, "size", len(update.Data))
+			c.reportError(ErrorCategorySizeLimit, message, update, ErrUpdateTooLarge)
+			c.replyError(ErrUpdateTooLarge, c.extraOf(update)...)
+			return
 		}
+	}
+
+	c.dispatcher.dispatch(update, func() {
+		c.processMessage(message, update, decodeErr, decoded)
+	})
+}
 
-		var update *Update
-		jsonErr := json.Unmarshal(message, &update)
-		if jsonErr != nil {
-			c.server.opts.logger.Error(fmt.Sprintf("Error Unmarshalling Request: %s. Data: %s. RemoteAddr: %s", jsonErr, message, c.ws.RemoteAddr().String()))
-			c.reportError(message, jsonErr)
-			continue
+// processMessage runs middlewares and handlers for a single message
+func (c *Connection) processMessage(message []byte, update *Update, decodeErr error, decoded bool) {
+	var span *Span
+
+	defer func() {
+		if !c.server.opts.disablePanicRecovery {
+			if r := recover(); r != nil {
+				c.handlePanic(message, update, r)
+				span.RecordError(fmt.Errorf("panic: %v", r))
+			}
 		}
+		span.End()
+	}()
 
-		if update.Type == "" {
-			c.server.opts.logger.Error(fmt.Sprintf("Error Due to Empty Update Type. Data: %s. RemoteAddr: %s", message, c.ws.RemoteAddr().String()))
-			c.reportError(message, errors.New("empty update type"), update.Extra)
-			continue
+	if c.middleware != nil {
+		if err := c.middleware(message); err != nil {
+			c.logger.Error("Error from middleware", "error", err)
+			c.reportError(ErrorCategoryMiddleware, message, nil, err)
+			c.replyError(err)
+			return
 		}
+	}
 
-		if c.middlewareForUpdate != nil {
-			if err := c.middlewareForUpdate(update.Type, update.Data); err != nil {
-				c.server.opts.logger.Error(fmt.Sprintf("Error From Middleware: %s. RemoteAddr: %s", err, c.ws.RemoteAddr().String()))
-				c.reportError(message, err, update.Extra)
-				continue
-			}
+	if rawHandler := c.getRawHandler(); rawHandler != nil {
+		rawHandler(message)
+		return
+	}
+
+	if !decoded {
+		decodeErr = json.Unmarshal(message, &update)
+	}
+
+	if decodeErr != nil {
+		c.logger.Error("Error unmarshalling update", "error", decodeErr, "data", string(message))
+		c.reportError(ErrorCategoryDecode, message, nil, decodeErr)
+		c.replyError(NewError(ErrorCodeBadRequest, "invalid update"))
+		return
+	}
+
+	if update == nil || update.Type == "" {
+		c.logger.Error("Empty update type", "data", string(message))
+		var extra []string
+		if update != nil {
+			extra = c.extraOf(update)
 		}
+		c.reportError(ErrorCategoryDecode, message, update, errors.New("empty update type"))
+		c.replyError(NewError(ErrorCodeBadRequest, "empty update type"), extra...)
+		return
+	}
 
-		// Check if there's a default handler registered for the updateType and call it
-		// If any handlers found, the update will be processed by that handler and won't be passed to the updates channel
-		if handler := c.getHandlerByType(update.Type); handler != nil {
-			err = handler.Handle(update.Data)
-			if err != nil {
-				c.server.opts.logger.Error(fmt.Sprintf("Error handling event: %s : %s from %s", string(message), err, c.ws.RemoteAddr().String()))
-				c.reportError(message, err, update.Extra)
-			}
-			continue
+	var ctx context.Context
+	ctx, span = c.traceUpdate(update)
+
+	if c.middlewareForUpdate != nil {
+		if err := c.middlewareForUpdate(update.Type, update.Data); err != nil {
+			span.RecordError(err)
+			c.logger.Error("Error from middleware", "error", err, "update_type", update.Type)
+			c.reportError(ErrorCategoryMiddleware, message, update, err)
+			c.replyError(err, c.extraOf(update)...)
+			return
 		}
 	}
+
+	start := time.Now()
+	err := c.updateHandler(update.Type)(ctx, update)
+	c.server.metrics.observeHandler(update.Type, time.Since(start))
+	if err != nil {
+		span.RecordError(err)
+		c.logger.Error("Error handling update", "error", err, "update_type", update.Type, "data", string(message))
+		category := ErrorCategoryHandler
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			category = ErrorCategoryValidation
+		}
+
+		c.reportError(category, message, update, err)
+		c.replyError(err, c.extraOf(update)...)
+	}
 }
 
 func (c *Connection) getRawHandler() func(message []byte) {
@@ -254,18 +479,50 @@ func (c *Connection) getHandlerByType(t string) mapper {
 	return nil
 }
 
-func (c *Connection) close() error {
-	defer func() {
-		c.closed <- true
-	}()
+func (c *Connection) tooLarge(update *Update) bool {
+	maxSize, ok := c.server.opts.maxUpdateDataSizes[update.Type]
 
-	if c.server.storage != nil {
-		c.server.storage.removeClientByID(c.id)
-	}
+	return ok && len(update.Data) > maxSize
+}
 
-	if c.onClose != nil {
-		go c.onClose()
+func (c *Connection) extraOf(update *Update) []string {
+	if update.Extra == "" {
+		return nil
 	}
 
-	return c.ws.Close()
+	return []string{update.Extra}
+}
+
+func (c *Connection) close() error {
+	return c.closeWithCause(ErrConnectionClosed)
+}
+
+func (c *Connection) closeWithCause(cause error) (err error) {
+	c.closeOnce.Do(func() {
+		c.cancel(cause)
+		c.clientErrors.close()
+		c.server.metrics.connectionClosed(cause)
+
+		if c.release != nil {
+			c.release()
+		}
+
+		if c.server.storage != nil {
+			c.server.storage.removeClient(c)
+		}
+
+		c.brokerM.Lock()
+		if c.brokerUnsubscribe != nil {
+			c.brokerUnsubscribe()
+		}
+		c.brokerM.Unlock()
+
+		if c.onClose != nil {
+			go c.onClose()
+		}
+
+		err = c.ws.Close()
+	})
+
+	return
 }
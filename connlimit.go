@@ -0,0 +1,199 @@
+package socketify
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrTooManyConnections  = errors.New("too_many_connections")
+	ErrInvalidTrustedProxy = errors.New("invalid_trusted_proxy")
+)
+
+// ConnectionCounts is a snapshot of the connections tracked by the server's connection limiter
+type ConnectionCounts struct {
+	Total int
+	PerIP map[string]int
+	// PerAttribute maps attribute keys passed to SetMaxConnectionsPerAttribute to the number of connections per value
+	PerAttribute map[string]map[string]int
+}
+
+type connLimiter struct {
+	m            sync.Mutex
+	total        int
+	perIP        map[string]int
+	perAttribute map[string]map[string]int
+}
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{
+		perIP:        map[string]int{},
+		perAttribute: map[string]map[string]int{},
+	}
+}
+
+// reserve takes a slot for a new connection, it returns the http status to reject the upgrade with if a limit is reached
+// The returned release function gives the slot back
+func (l *connLimiter) reserve(opts *options, ip string, attributes map[string]interface{}) (release func(), status int) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if opts.maxConnections > 0 && l.total >= opts.maxConnections {
+		return nil, http.StatusServiceUnavailable
+	}
+
+	if opts.maxConnectionsPerIP > 0 && l.perIP[ip] >= opts.maxConnectionsPerIP {
+		return nil, http.StatusTooManyRequests
+	}
+
+	values := map[string]string{}
+	for key, limit := range opts.maxConnectionsPerAttribute {
+		val, ok := attributes[key]
+		if !ok {
+			continue
+		}
+
+		values[key] = fmt.Sprint(val)
+		if l.perAttribute[key][values[key]] >= limit {
+			return nil, http.StatusTooManyRequests
+		}
+	}
+
+	l.total++
+	l.perIP[ip]++
+	for key, val := range values {
+		if l.perAttribute[key] == nil {
+			l.perAttribute[key] = map[string]int{}
+		}
+		l.perAttribute[key][val]++
+	}
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			l.release(ip, values)
+		})
+	}, 0
+}
+
+func (l *connLimiter) release(ip string, values map[string]string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.total--
+
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+
+	for key, val := range values {
+		if l.perAttribute[key][val]--; l.perAttribute[key][val] <= 0 {
+			delete(l.perAttribute[key], val)
+		}
+	}
+}
+
+func (l *connLimiter) counts() ConnectionCounts {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	counts := ConnectionCounts{
+		Total:        l.total,
+		PerIP:        make(map[string]int, len(l.perIP)),
+		PerAttribute: make(map[string]map[string]int, len(l.perAttribute)),
+	}
+
+	for ip, n := range l.perIP {
+		counts.PerIP[ip] = n
+	}
+
+	for key, values := range l.perAttribute {
+		counts.PerAttribute[key] = make(map[string]int, len(values))
+		for val, n := range values {
+			counts.PerAttribute[key][val] = n
+		}
+	}
+
+	return counts
+}
+
+// ConnectionCounts returns the number of open connections in total, per IP and per limited attribute value
+func (s *Server) ConnectionCounts() ConnectionCounts {
+	return s.connLimiter.counts()
+}
+
+// parseTrustedProxy parses an IP or a CIDR
+func parseTrustedProxy(proxy string) (*net.IPNet, error) {
+	if !strings.Contains(proxy, "/") {
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTrustedProxy, proxy)
+		}
+
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %s", ErrInvalidTrustedProxy, proxy, err)
+	}
+
+	return ipNet, nil
+}
+
+func isTrustedProxy(trusted []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, ipNet := range trusted {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteIP returns the client's IP
+// X-Forwarded-For is only used when the request comes from a trusted proxy, it's walked from right to left
+// and the first address that isn't a trusted proxy is returned
+func remoteIP(r *http.Request, trusted []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if len(trusted) == 0 || !isTrustedProxy(trusted, ip) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(header, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				forwarded = append(forwarded, addr)
+			}
+		}
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if !isTrustedProxy(trusted, forwarded[i]) {
+			return forwarded[i]
+		}
+		ip = forwarded[i]
+	}
+
+	return ip
+}
@@ -0,0 +1,217 @@
+package socketify_test
+
+import (
+	"github.com/aliforever/go-socketify"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runLimitedServer upgrades every request, the "X-User" header is copied to the "user_id" attribute
+// The remote IP of every request is sent on ips
+func runLimitedServer(t *testing.T, server *socketify.Server) (address string, ips <-chan string) {
+	hs := httptest.NewServer(server)
+	t.Cleanup(hs.Close)
+
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+	})
+
+	remoteIPs := make(chan string, 16)
+
+	go func() {
+		for {
+			var request *socketify.UpgradeRequest
+
+			select {
+			case request = <-server.UpgradeRequests():
+			case <-done:
+				return
+			}
+
+			if user := request.Request().Header.Get("X-User"); user != "" {
+				request.SetAttribute("user_id", user)
+			}
+
+			select {
+			case remoteIPs <- request.RemoteIP():
+			default:
+			}
+
+			if connection, err := request.Upgrade(); err == nil {
+				go connection.ProcessUpdates()
+			}
+		}
+	}()
+
+	return "ws" + strings.TrimPrefix(hs.URL, "http"), remoteIPs
+}
+
+func dialLimited(t *testing.T, address string, header http.Header) (*websocket.Conn, int) {
+	t.Helper()
+
+	conn, resp, err := websocket.DefaultDialer.Dial(address, header)
+	if err != nil {
+		if resp == nil {
+			t.Fatal(err)
+		}
+		return nil, resp.StatusCode
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn, http.StatusSwitchingProtocols
+}
+
+func TestConnectionLimits(t *testing.T) {
+	user := func(id string) http.Header {
+		return http.Header{"X-User": []string{id}}
+	}
+
+	tests := []struct {
+		name       string
+		server     func() *socketify.Server
+		first      http.Header
+		rejected   http.Header
+		wantStatus int
+		accepted   http.Header
+		wantCounts func(t *testing.T, counts socketify.ConnectionCounts)
+	}{
+		{
+			name: "Total",
+			server: func() *socketify.Server {
+				return socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetMaxConnections(1))
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCounts: func(t *testing.T, counts socketify.ConnectionCounts) {
+				assert.Equal(t, 1, counts.Total)
+			},
+		},
+		{
+			name: "PerIP",
+			server: func() *socketify.Server {
+				return socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetMaxConnectionsPerIP(1))
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantCounts: func(t *testing.T, counts socketify.ConnectionCounts) {
+				assert.Equal(t, map[string]int{"127.0.0.1": 1}, counts.PerIP)
+			},
+		},
+		{
+			name: "PerAttribute",
+			server: func() *socketify.Server {
+				return socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetMaxConnectionsPerAttribute("user_id", 1))
+			},
+			first:      user("1"),
+			rejected:   user("1"),
+			wantStatus: http.StatusTooManyRequests,
+			accepted:   user("2"),
+			wantCounts: func(t *testing.T, counts socketify.ConnectionCounts) {
+				assert.Equal(t, map[string]map[string]int{"user_id": {"1": 1, "2": 1}}, counts.PerAttribute)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.server()
+			address, _ := runLimitedServer(t, server)
+
+			first, status := dialLimited(t, address, tt.first)
+			assert.Equal(t, http.StatusSwitchingProtocols, status)
+
+			_, status = dialLimited(t, address, tt.rejected)
+			assert.Equal(t, tt.wantStatus, status)
+
+			if tt.accepted != nil {
+				_, status = dialLimited(t, address, tt.accepted)
+				assert.Equal(t, http.StatusSwitchingProtocols, status)
+			}
+
+			tt.wantCounts(t, server.ConnectionCounts())
+
+			// Closing the first connection releases its slot
+			_ = first.Close()
+			assert.Eventually(t, func() bool {
+				_, status := dialLimited(t, address, tt.rejected)
+				return status == http.StatusSwitchingProtocols
+			}, time.Second, time.Millisecond*20)
+		})
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		forwardedFor   string
+		want           string
+	}{
+		{
+			name:         "NoTrustedProxy",
+			forwardedFor: "9.9.9.9",
+			want:         "127.0.0.1",
+		},
+		{
+			name:           "UntrustedPeer",
+			trustedProxies: []string{"10.0.0.0/8"},
+			forwardedFor:   "9.9.9.9",
+			want:           "127.0.0.1",
+		},
+		{
+			name:           "TrustedPeer",
+			trustedProxies: []string{"127.0.0.1"},
+			forwardedFor:   "9.9.9.9",
+			want:           "9.9.9.9",
+		},
+		{
+			name:           "ProxyChain",
+			trustedProxies: []string{"127.0.0.0/8", "10.0.0.0/8"},
+			forwardedFor:   "8.8.8.8, 9.9.9.9, 10.0.0.2",
+			want:           "9.9.9.9",
+		},
+		{
+			name:           "NoForwardedFor",
+			trustedProxies: []string{"127.0.0.1"},
+			want:           "127.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := socketify.NewServer(socketify.ServerOptions().
+				SetLogger(socketify.NopLogger()).
+				SetTrustedProxies(tt.trustedProxies...).
+				SetMaxConnectionsPerIP(1))
+			address, ips := runLimitedServer(t, server)
+
+			header := http.Header{}
+			if tt.forwardedFor != "" {
+				header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			_, status := dialLimited(t, address, header)
+			assert.Equal(t, http.StatusSwitchingProtocols, status)
+			assert.Equal(t, tt.want, <-ips)
+			assert.Equal(t, map[string]int{tt.want: 1}, server.ConnectionCounts().PerIP)
+		})
+	}
+}
+
+func TestInvalidTrustedProxies(t *testing.T) {
+	var server *socketify.Server
+
+	assert.NotPanics(t, func() {
+		server = socketify.NewServer(socketify.ServerOptions().
+			SetLogger(socketify.NopLogger()).
+			SetTrustedProxies("127.0.0.1", "proxy.local", "10.0.0.0/33"))
+	})
+
+	assert.ErrorIs(t, server.Listen(), socketify.ErrInvalidTrustedProxy)
+}
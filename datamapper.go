@@ -1,32 +1,143 @@
 package socketify
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
 )
 
 type EmptyInput struct{}
 
 type mapper interface {
-	Handle(message json.RawMessage) error
+	Handle(ctx context.Context, message json.RawMessage, extra ...string) error
 }
 
 type dataMapper[T any] struct {
-	handler func(T, ...string) error
+	handler        func(T, ...string) error
+	handlerContext func(context.Context, T, ...string) error
+
+	validate bool
+	strict   bool
+	schema   *jsonSchema
 }
 
-func (u dataMapper[T]) Handle(data json.RawMessage) error {
+func (u dataMapper[T]) Handle(ctx context.Context, data json.RawMessage, extra ...string) error {
 	var t T
 
 	if _, ok := any(t).(EmptyInput); !ok {
-		err := json.Unmarshal(data, &t)
-		if err != nil {
+		if err := u.decode(data, &t); err != nil {
 			return err
 		}
 	}
 
-	return u.handler(t)
+	if u.handlerContext != nil {
+		return u.handlerContext(ctx, t, extra...)
+	}
+
+	return u.handler(t, extra...)
+}
+
+func (u dataMapper[T]) decode(data json.RawMessage, t *T) error {
+	validating := u.validate || u.strict || u.schema != nil
+
+	if validating && len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+
+	if u.schema != nil {
+		if errs := u.schema.validateDocument(data); len(errs) > 0 {
+			return &ValidationError{Fields: errs}
+		}
+	}
+
+	if u.validate {
+		return u.decodeAndValidate(data, t)
+	}
+
+	var err error
+	if u.strict {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(t)
+	} else {
+		err = json.Unmarshal(data, t)
+	}
+
+	if err != nil {
+		if validating {
+			return decodeError(err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// decodeAndValidate decodes leniently so every wrong type and unknown field is reported together with the
+// failed `validate` tags, a field that couldn't be decoded isn't also reported by its tags
+func (u dataMapper[T]) decodeAndValidate(data json.RawMessage, t *T) error {
+	err := json.Unmarshal(data, t)
+
+	var typeErr *json.UnmarshalTypeError
+	if err != nil && !errors.As(err, &typeErr) {
+		return decodeError(err)
+	}
+
+	var errs []FieldError
+	if err != nil || u.strict {
+		errs = decodeErrors(reflect.TypeOf(t).Elem(), data, "", u.strict)
+	}
+	if err != nil && len(errs) == 0 {
+		return decodeError(err)
+	}
+
+	errs = append(errs, withoutFieldsOf(validateTags(reflect.ValueOf(t), data, ""), errs)...)
+
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+
+	return nil
+}
+
+// Validate enables validation of the `validate` struct tags of T before the handler is called:
+// required, min=n, max=n, enum=a|b|c and regex=pattern (regex must be the last rule of a tag)
+// e.g. Amount int `json:"amount" validate:"required,min=1,max=1000"`
+// Fields of the wrong type, and unknown fields with Strict, are reported in the same error as the failed tags
+func (u dataMapper[T]) Validate() dataMapper[T] {
+	u.validate = true
+	return u
+}
+
+// Strict rejects data containing fields that T doesn't have
+func (u dataMapper[T]) Strict() dataMapper[T] {
+	u.strict = true
+	return u
+}
+
+// WithSchema validates the data against a JSON Schema document before it's decoded
+// Supported keywords: type, properties, required, additionalProperties, items, enum, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern, minItems and maxItems
+// It panics if the document isn't a valid schema
+func (u dataMapper[T]) WithSchema(document []byte) dataMapper[T] {
+	schema, err := compileJSONSchema(document)
+	if err != nil {
+		panic(fmt.Sprintf("socketify: invalid json schema: %s", err))
+	}
+
+	u.schema = schema
+	return u
 }
 
 func DataMapper[T any](handler func(T, ...string) error) dataMapper[T] {
 	return dataMapper[T]{handler: handler}
 }
+
+// DataMapperContext is like DataMapper but the handler also receives the connection's context
+// The context is cancelled when the connection closes
+func DataMapperContext[T any](handler func(context.Context, T, ...string) error) dataMapper[T] {
+	return dataMapper[T]{handlerContext: handler}
+}
@@ -0,0 +1,158 @@
+package socketify
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+type dispatchKind int
+
+const (
+	dispatchSequential dispatchKind = iota
+	dispatchConcurrent
+	dispatchKeyed
+)
+
+// KeyFunc returns the ordering key of an update, updates with the same key are handled in order
+// update is nil for messages handled by a raw handler
+type KeyFunc func(update *Update) string
+
+// DispatchMode decides how handlers run relative to the goroutine reading the socket
+type DispatchMode struct {
+	kind       dispatchKind
+	maxWorkers int
+	keyFunc    KeyFunc
+}
+
+// DispatchSequential runs handlers one by one on the reading goroutine, a slow handler delays further reads
+func DispatchSequential() DispatchMode {
+	return DispatchMode{kind: dispatchSequential}
+}
+
+// DispatchConcurrent runs every handler in its own goroutine without any ordering
+// At most maxWorkers handlers run at once (0 means unlimited), reading blocks while the limit is reached
+func DispatchConcurrent(maxWorkers int) DispatchMode {
+	return DispatchMode{kind: dispatchConcurrent, maxWorkers: maxWorkers}
+}
+
+// DispatchKeyed runs updates with the same key in order and updates with different keys in parallel
+// At most maxWorkers updates are queued or running at once (0 means unlimited), reading blocks while the limit is reached
+func DispatchKeyed(maxWorkers int, keyFunc KeyFunc) DispatchMode {
+	if keyFunc == nil {
+		keyFunc = KeyByUpdateType
+	}
+
+	return DispatchMode{kind: dispatchKeyed, maxWorkers: maxWorkers, keyFunc: keyFunc}
+}
+
+func KeyByUpdateType(update *Update) string {
+	if update == nil {
+		return ""
+	}
+
+	return update.Type
+}
+
+func KeyByExtra(update *Update) string {
+	if update == nil {
+		return ""
+	}
+
+	return update.Extra
+}
+
+// KeyByDataField keys updates by a top level field of their data, e.g. KeyByDataField("order_id")
+func KeyByDataField(field string) KeyFunc {
+	return func(update *Update) string {
+		if update == nil || len(update.Data) == 0 {
+			return ""
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(update.Data, &fields); err != nil {
+			return ""
+		}
+
+		return string(fields[field])
+	}
+}
+
+type dispatcher struct {
+	mode DispatchMode
+	sem  chan struct{}
+
+	m      sync.Mutex
+	queues map[string][]func()
+}
+
+func newDispatcher(mode DispatchMode) *dispatcher {
+	d := &dispatcher{
+		mode:   mode,
+		queues: map[string][]func(){},
+	}
+
+	if mode.maxWorkers > 0 {
+		d.sem = make(chan struct{}, mode.maxWorkers)
+	}
+
+	return d
+}
+
+func (d *dispatcher) dispatch(update *Update, fn func()) {
+	switch d.mode.kind {
+	case dispatchConcurrent:
+		d.acquire()
+		go func() {
+			defer d.release()
+			fn()
+		}()
+	case dispatchKeyed:
+		d.acquire()
+		d.enqueue(d.mode.keyFunc(update), fn)
+	default:
+		fn()
+	}
+}
+
+func (d *dispatcher) enqueue(key string, fn func()) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	queue, running := d.queues[key]
+	d.queues[key] = append(queue, fn)
+
+	if !running {
+		go d.drain(key)
+	}
+}
+
+// drain runs the queued functions of key one by one and removes the queue once it's empty
+func (d *dispatcher) drain(key string) {
+	for {
+		d.m.Lock()
+		queue := d.queues[key]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.m.Unlock()
+			return
+		}
+		fn := queue[0]
+		d.queues[key] = queue[1:]
+		d.m.Unlock()
+
+		fn()
+		d.release()
+	}
+}
+
+func (d *dispatcher) acquire() {
+	if d.sem != nil {
+		d.sem <- struct{}{}
+	}
+}
+
+func (d *dispatcher) release() {
+	if d.sem != nil {
+		<-d.sem
+	}
+}
@@ -0,0 +1,108 @@
+package socketify_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aliforever/go-socketify"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientDispatchKeyed(t *testing.T) {
+	const perKey = 20
+
+	keys := []string{"a", "b", "c"}
+
+	var messages []string
+	for i := 0; i < perKey; i++ {
+		for _, key := range keys {
+			messages = append(messages, fmt.Sprintf(`{"type":"seq","data":{"key":"%s","seq":%d}}`, key, i))
+		}
+	}
+
+	address := runWritingServer(t, messages)
+
+	opts := socketify.ClientOptions().SetLogger(socketify.NopLogger()).SetDispatchMode(socketify.DispatchKeyed(4, socketify.KeyByDataField("key")))
+
+	client, err := socketify.NewClientWithOptions(address, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close(websocket.CloseNormalClosure, "done")
+
+	var (
+		m   sync.Mutex
+		got = map[string][]int{}
+		wg  sync.WaitGroup
+	)
+
+	wg.Add(len(messages))
+
+	client.SetUpdateTypeHandler("seq", func(message json.RawMessage) {
+		defer wg.Done()
+
+		var data struct {
+			Key string `json:"key"`
+			Seq int    `json:"seq"`
+		}
+		_ = json.Unmarshal(message, &data)
+
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+
+		m.Lock()
+		defer m.Unlock()
+		got[data.Key] = append(got[data.Key], data.Seq)
+	})
+
+	if err := client.WriteText("ready"); err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+
+	for _, key := range keys {
+		assert.Len(t, got[key], perKey)
+		for i, seq := range got[key] {
+			assert.Equal(t, i, seq, "key %s out of order", key)
+		}
+	}
+}
+
+// runWritingServer starts a plain websocket server that writes messages once the client sends its first message
+func runWritingServer(t *testing.T, messages []string) string {
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+
+		for _, message := range messages {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+				return
+			}
+		}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
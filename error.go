@@ -0,0 +1,99 @@
+package socketify
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ErrorCodeBadRequest     = "bad_request"
+	ErrorCodeValidation     = "validation_failed"
+	ErrorCodeRateLimited    = "rate_limited"
+	ErrorCodeUpdateTooLarge = "update_too_large"
+	ErrorCodeInternal       = "internal_error"
+)
+
+const defaultErrorReplyType = "error"
+
+// Error is a structured error, handlers can return it to control the error reply sent to the client
+// It's sent as {"type": "error", "data": {"code": "", "message": "", "details": ...}, "extra": "<echoed extra>"}
+// when error replies are enabled with options.EnableErrorReplies
+type Error struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func NewError(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) WithDetails(details interface{}) *Error {
+	e.Details = details
+	return e
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// toError converts errors to the *Error sent to clients
+// Errors that aren't known to socketify become a generic internal error so no internals are leaked
+func toError(err error) *Error {
+	var (
+		e             *Error
+		validationErr *ValidationError
+	)
+
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.As(err, &validationErr):
+		return NewError(ErrorCodeValidation, "validation failed").WithDetails(validationErr.Fields)
+	case errors.Is(err, ErrRateLimited):
+		return NewError(ErrorCodeRateLimited, "rate limit exceeded")
+	case errors.Is(err, ErrUpdateTooLarge):
+		return NewError(ErrorCodeUpdateTooLarge, "update data is too large")
+	}
+
+	return NewError(ErrorCodeInternal, "internal error")
+}
+
+func (c *Connection) errorRepliesEnabled() bool {
+	return c.server.opts.errorReplyType != ""
+}
+
+// replyError sends an error reply to the client if error replies are enabled
+// Validation errors are always replied to, as a "validation_error" update when error replies are disabled
+func (c *Connection) replyError(err error, extra ...string) {
+	var (
+		updateType string
+		data       interface{}
+	)
+
+	var validationErr *ValidationError
+
+	switch {
+	case c.errorRepliesEnabled():
+		updateType, data = c.server.opts.errorReplyType, toError(err)
+	case errors.As(err, &validationErr):
+		updateType, data = "validation_error", validationErr
+	default:
+		return
+	}
+
+	if writeErr := c.WriteUpdate(updateType, data, extra...); writeErr != nil {
+		c.logger.Error("Error writing error reply", "error", writeErr, "update_type", updateType)
+	}
+}
+
+// SetOnErrorReply registers a callback for error replies sent by a socketify server with error replies enabled
+// extra is the extra of the update that failed
+func (c *Client) SetOnErrorReply(fn func(err *Error, extra string)) *Client {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
+	c.onErrorReply = fn
+
+	return c
+}
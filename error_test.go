@@ -0,0 +1,160 @@
+package socketify_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type amountInput struct {
+	Amount int `json:"amount" validate:"min=1"`
+}
+
+func TestErrorReplies(t *testing.T) {
+	tests := []struct {
+		name       string
+		updateType string
+		message    string
+		want       string
+	}{
+		{
+			name:       "PlainError",
+			updateType: "plain",
+			message:    `{"type": "plain", "extra": "1"}`,
+			want:       `{"type": "error", "data": {"code": "internal_error", "message": "internal error"}, "extra": "1"}`,
+		},
+		{
+			name:       "TypedError",
+			updateType: "typed",
+			message:    `{"type": "typed", "extra": "2"}`,
+			want:       `{"type": "error", "data": {"code": "insufficient_funds", "message": "balance too low", "details": {"balance": 5}}, "extra": "2"}`,
+		},
+		{
+			name:       "WrappedTypedError",
+			updateType: "wrapped",
+			message:    `{"type": "wrapped", "extra": "3"}`,
+			want:       `{"type": "error", "data": {"code": "insufficient_funds", "message": "balance too low", "details": {"balance": 5}}, "extra": "3"}`,
+		},
+		{
+			name:       "ValidationError",
+			updateType: "validated",
+			message:    `{"type": "validated", "data": {"amount": 0}, "extra": "4"}`,
+			want:       `{"type": "error", "data": {"code": "validation_failed", "message": "validation failed", "details": [{"field": "amount", "rule": "min", "message": "must be at least 1"}]}, "extra": "4"}`,
+		},
+		{
+			name:    "DecodeError",
+			message: `{not json`,
+			want:    `{"type": "error", "data": {"code": "bad_request", "message": "invalid update"}}`,
+		},
+	}
+
+	typedErr := socketify.NewError("insufficient_funds", "balance too low").WithDetails(map[string]int{"balance": 5})
+
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).EnableErrorReplies()), func(c *socketify.Connection) {
+		c.HandleUpdate("plain", socketify.DataMapper[socketify.EmptyInput](func(socketify.EmptyInput, ...string) error {
+			return errors.New("database is down")
+		}))
+		c.HandleUpdate("typed", socketify.DataMapper[socketify.EmptyInput](func(socketify.EmptyInput, ...string) error {
+			return typedErr
+		}))
+		c.HandleUpdate("wrapped", socketify.DataMapper[socketify.EmptyInput](func(socketify.EmptyInput, ...string) error {
+			return fmt.Errorf("transfer: %w", typedErr)
+		}))
+		c.HandleUpdate("validated", socketify.DataMapper[amountInput](func(amountInput, ...string) error {
+			return nil
+		}).Validate())
+	})
+
+	got, connection := server.Pair()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, got.WriteText(tt.message))
+
+			select {
+			case ue := <-connection.Errors():
+				assert.Equal(t, tt.updateType, ue.UpdateType)
+			case <-time.After(time.Second):
+				t.Fatal("error was not reported")
+			}
+
+			assert.JSONEq(t, tt.want, string(got.ExpectRaw(time.Second)))
+		})
+	}
+}
+
+func TestClientOnErrorReply(t *testing.T) {
+	opts := socketify.ServerOptions().SetLogger(socketify.NopLogger()).EnableErrorReplies().SetErrorReplyType("failure")
+
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(opts), func(c *socketify.Connection) {
+		c.HandleUpdate("typed", socketify.DataMapper[socketify.EmptyInput](func(socketify.EmptyInput, ...string) error {
+			return socketify.NewError("insufficient_funds", "balance too low").WithDetails(map[string]int{"balance": 5})
+		}))
+		c.HandleUpdate("plain", socketify.DataMapper[socketify.EmptyInput](func(socketify.EmptyInput, ...string) error {
+			return errors.New("database is down")
+		}))
+	})
+
+	got, _ := server.PairWith(func(url string, dialer *websocket.Dialer) (*socketify.Client, error) {
+		// Replies are compared in order
+		return socketify.NewClientWithOptions(url, socketify.ClientOptions().
+			SetLogger(socketify.NopLogger()).
+			SetDialer(dialer).
+			SetErrorReplyType("failure").
+			SetDispatchMode(socketify.DispatchSequential()))
+	})
+
+	type reply struct {
+		err   *socketify.Error
+		extra string
+	}
+	replies := make(chan reply, 2)
+
+	// Replaces the harness' raw handler so error replies reach the callback
+	got.SetRawHandler(nil)
+	got.SetOnErrorReply(func(err *socketify.Error, extra string) {
+		replies <- reply{err: err, extra: extra}
+	})
+
+	got.SendUpdate("typed", nil, "1")
+	got.SendUpdate("plain", nil, "2")
+
+	for _, want := range []reply{
+		{err: &socketify.Error{Code: "insufficient_funds", Message: "balance too low", Details: map[string]interface{}{"balance": float64(5)}}, extra: "1"},
+		{err: &socketify.Error{Code: socketify.ErrorCodeInternal, Message: "internal error"}, extra: "2"},
+	} {
+		select {
+		case r := <-replies:
+			assert.Equal(t, want, r)
+		case <-time.After(time.Second):
+			t.Fatalf("no error reply for extra %s", want.extra)
+		}
+	}
+}
+
+func TestReadErrorDoesNotBlock(t *testing.T) {
+	opts := socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetErrorBuffer(1, socketify.ErrorOverflowBlock)
+
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(opts), nil)
+	got, connection := server.Pair()
+
+	reported := make(chan socketify.ErrorCategory, 2)
+	connection.OnError(func(ue socketify.UpdateError) {
+		reported <- ue.Category
+	})
+
+	// Fills the buffer nobody reads
+	assert.NoError(t, got.WriteText(`{not json`))
+	assert.Equal(t, socketify.ErrorCategoryDecode, <-reported)
+
+	got.Close(websocket.CloseNormalClosure, "")
+	socketifytest.AssertConnectionClosedWith(t, connection, websocket.CloseNormalClosure)
+
+	assert.Equal(t, socketify.ErrorCategoryRead, <-reported)
+	assert.Equal(t, uint64(1), connection.DroppedErrors())
+}
@@ -0,0 +1,78 @@
+package socketify
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFrameDropped is returned by interceptors to drop a frame, the write call returns it to the caller
+var ErrFrameDropped = errors.New("frame_dropped")
+
+// OutgoingFrame is what an OutgoingInterceptor sees before a message is encoded and written
+type OutgoingFrame struct {
+	// MessageType is the websocket message type (websocket.TextMessage or websocket.BinaryMessage)
+	MessageType int
+	// UpdateType, Extra and Meta are only set for messages written by WriteUpdate
+	UpdateType string
+	Extra      string
+	// Meta is sent as the "meta" field of the update, interceptors can add fields such as timestamps to it
+	Meta map[string]interface{}
+	// Data is the update data for WriteUpdate, the value passed to WriteRawUpdate,
+	// a string for WriteText and a []byte for WriteBinaryBytes and WriteBinaryText
+	Data interface{}
+	// Connection is the target connection, it's nil for frames written by a Client
+	Connection *Connection
+}
+
+// IsUpdate reports whether the frame was written by WriteUpdate
+func (f *OutgoingFrame) IsUpdate() bool {
+	return f.UpdateType != ""
+}
+
+// OutgoingInterceptor inspects and mutates outgoing frames
+// Return ErrFrameDropped (or any other error) to drop the frame, the error is returned to the writer
+type OutgoingInterceptor func(frame *OutgoingFrame) error
+
+// AddOutgoingInterceptor appends interceptors to the chain that runs before every outgoing message is encoded
+func (w *writer) AddOutgoingInterceptor(interceptors ...OutgoingInterceptor) {
+	w.interceptorsLock.Lock()
+	defer w.interceptorsLock.Unlock()
+
+	w.interceptors = append(w.interceptors, interceptors...)
+}
+
+func (w *writer) getInterceptors() []OutgoingInterceptor {
+	w.interceptorsLock.Lock()
+	defer w.interceptorsLock.Unlock()
+
+	return w.interceptors
+}
+
+func (w *writer) intercept(message messageType) error {
+	interceptors := w.getInterceptors()
+	if len(interceptors) == 0 {
+		return nil
+	}
+
+	frame := message.frame()
+	frame.Connection = w.connection
+
+	for _, interceptor := range interceptors {
+		if err := interceptor(frame); err != nil {
+			return err
+		}
+	}
+
+	return message.applyFrame(frame)
+}
+
+func frameBytes(frame *OutgoingFrame) ([]byte, error) {
+	switch data := frame.Data.(type) {
+	case []byte:
+		return data, nil
+	case string:
+		return []byte(data), nil
+	default:
+		return nil, fmt.Errorf("invalid_frame_data_type_%T", frame.Data)
+	}
+}
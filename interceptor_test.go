@@ -0,0 +1,89 @@
+package socketify_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutgoingInterceptors(t *testing.T) {
+	var logs lockedBuffer
+
+	opts := socketify.ServerOptions().
+		SetLogger(socketify.NewLogger(&logs, socketify.LogLevelDebug)).
+		AddOutgoingInterceptor(func(frame *socketify.OutgoingFrame) error {
+			if frame.IsUpdate() {
+				frame.Meta = map[string]interface{}{"order": []string{"server"}}
+			}
+			return nil
+		})
+
+	connections := make(chan *socketify.Connection, 1)
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(opts), func(c *socketify.Connection) {
+		c.AddOutgoingInterceptor(func(frame *socketify.OutgoingFrame) error {
+			if !frame.IsUpdate() {
+				return nil
+			}
+
+			switch frame.UpdateType {
+			case "secret":
+				return socketify.ErrFrameDropped
+			case "admin":
+				return fmt.Errorf("%w: admins only", socketify.ErrFrameDropped)
+			case "profile":
+				profile := frame.Data.(map[string]string)
+				frame.Data = map[string]string{"name": profile["name"], "email": "[redacted]"}
+			}
+
+			frame.Meta["order"] = append(frame.Meta["order"].([]string), "connection")
+			frame.Meta["connection_id"] = frame.Connection.ID()
+
+			return nil
+		})
+		connections <- c
+	})
+
+	got, _ := server.Pair()
+	connection := <-connections
+
+	t.Run("Meta", func(t *testing.T) {
+		assert.NoError(t, connection.WriteUpdate("ping", 1))
+
+		var update struct {
+			Type string                 `json:"type"`
+			Meta map[string]interface{} `json:"meta"`
+		}
+		assert.NoError(t, json.Unmarshal(got.ExpectRaw(time.Second), &update))
+		assert.Equal(t, "ping", update.Type)
+		assert.Equal(t, map[string]interface{}{
+			"order":         []interface{}{"server", "connection"},
+			"connection_id": connection.ID(),
+		}, update.Meta)
+	})
+
+	t.Run("Mutate", func(t *testing.T) {
+		assert.NoError(t, connection.WriteUpdate("profile", map[string]string{"name": "ali", "email": "ali@example.com"}))
+
+		update := got.ExpectUpdate("profile", time.Second)
+		assert.JSONEq(t, `{"name": "ali", "email": "[redacted]"}`, string(update.Data))
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		for _, updateType := range []string{"secret", "admin"} {
+			assert.ErrorIs(t, connection.WriteUpdate(updateType, 1), socketify.ErrFrameDropped)
+		}
+		got.ExpectNoUpdate(time.Millisecond * 50)
+
+		assert.False(t, strings.Contains(string(logs.Bytes()), "outgoing interceptor"), "dropped frames must not be logged")
+	})
+
+	t.Run("RawMessages", func(t *testing.T) {
+		assert.NoError(t, connection.WriteText("hello"))
+		assert.Equal(t, "hello", string(got.ExpectRaw(time.Second)))
+	})
+}
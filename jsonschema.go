@@ -0,0 +1,243 @@
+package socketify
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema is the subset of JSON Schema supported by DataMapper.WithSchema:
+// type, properties, required, additionalProperties (boolean or schema), items, enum,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern, minItems and maxItems
+type jsonSchema struct {
+	Type                 jsonSchemaTypes        `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+
+	pattern              *regexp.Regexp
+	noAdditional         bool
+	additionalProperties *jsonSchema
+}
+
+// jsonSchemaTypes accepts both "type": "string" and "type": ["string", "null"]
+type jsonSchemaTypes []string
+
+func (t *jsonSchemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = jsonSchemaTypes{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+
+	*t = multiple
+	return nil
+}
+
+func compileJSONSchema(document []byte) (*jsonSchema, error) {
+	var schema *jsonSchema
+	if err := json.Unmarshal(document, &schema); err != nil {
+		return nil, err
+	}
+
+	if schema == nil {
+		return nil, fmt.Errorf("empty_schema")
+	}
+
+	return schema, schema.compile()
+}
+
+func (s *jsonSchema) compile() (err error) {
+	if s.Pattern != "" {
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+
+	if len(s.AdditionalProperties) > 0 {
+		var allowed bool
+		if json.Unmarshal(s.AdditionalProperties, &allowed) == nil {
+			s.noAdditional = !allowed
+		} else if err = json.Unmarshal(s.AdditionalProperties, &s.additionalProperties); err != nil {
+			return err
+		} else if err = s.additionalProperties.compile(); err != nil {
+			return err
+		}
+	}
+
+	for _, property := range s.Properties {
+		if err = property.compile(); err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+
+	return nil
+}
+
+func (s *jsonSchema) validateDocument(data json.RawMessage) []FieldError {
+	var value interface{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return []FieldError{{Rule: "type", Message: "must be valid JSON"}}
+		}
+	}
+
+	return s.validate(value, "")
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	return ""
+}
+
+func (s *jsonSchema) validate(value interface{}, path string) []FieldError {
+	var errs []FieldError
+
+	fail := func(rule, message string) {
+		errs = append(errs, FieldError{Field: path, Rule: rule, Message: message})
+	}
+
+	if len(s.Type) > 0 {
+		actual := jsonTypeOf(value)
+
+		matched := false
+		for _, t := range s.Type {
+			if t == actual || (t == "number" && actual == "integer") {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			fail("type", fmt.Sprintf("must be %s", strings.Join(s.Type, " or ")))
+			return errs
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			fail("enum", fmt.Sprintf("must be one of %v", s.Enum))
+		}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("minimum", fmt.Sprintf("must be at least %v", *s.Minimum))
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("maximum", fmt.Sprintf("must be at most %v", *s.Maximum))
+		}
+		if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+			fail("exclusiveMinimum", fmt.Sprintf("must be greater than %v", *s.ExclusiveMinimum))
+		}
+		if s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum {
+			fail("exclusiveMaximum", fmt.Sprintf("must be less than %v", *s.ExclusiveMaximum))
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("minLength", fmt.Sprintf("length must be at least %d", *s.MinLength))
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("maxLength", fmt.Sprintf("length must be at most %d", *s.MaxLength))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("pattern", fmt.Sprintf("must match %s", s.Pattern))
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("minItems", fmt.Sprintf("must have at least %d items", *s.MinItems))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("maxItems", fmt.Sprintf("must have at most %d items", *s.MaxItems))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		for _, required := range s.Required {
+			if _, ok := v[required]; !ok {
+				errs = append(errs, FieldError{Field: joinPath(path, required), Rule: "required", Message: "is required"})
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if property, ok := s.Properties[key]; ok {
+				errs = append(errs, property.validate(v[key], joinPath(path, key))...)
+			} else if s.noAdditional {
+				errs = append(errs, FieldError{Field: joinPath(path, key), Rule: "additionalProperties", Message: "unknown field"})
+			} else if s.additionalProperties != nil {
+				errs = append(errs, s.additionalProperties.validate(v[key], joinPath(path, key))...)
+			}
+		}
+	}
+
+	return errs
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+
+	return path + "." + field
+}
@@ -0,0 +1,172 @@
+package socketify
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	TimeoutReasonPong = "pong_timeout"
+	TimeoutReasonIdle = "idle_timeout"
+)
+
+// TimeoutError is reported to Client's onClose when the peer stops responding
+type TimeoutError struct {
+	Reason       string
+	Timeout      time.Duration
+	LastActivity time.Time
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: nothing received within %s (last activity at %s)", e.Reason, e.Timeout, e.LastActivity.Format(time.RFC3339Nano))
+}
+
+type keepAlive struct {
+	m            sync.Mutex
+	lastActivity time.Time
+	lastPing     time.Time
+	pingPayload  string
+	waitingPong  bool
+	latency      time.Duration
+	onLatency    func(rtt time.Duration)
+}
+
+func (k *keepAlive) touch(now time.Time) {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	k.lastActivity = now
+}
+
+func (k *keepAlive) pong(now time.Time, payload string) {
+	k.m.Lock()
+
+	k.lastActivity = now
+
+	if !k.waitingPong || payload != k.pingPayload {
+		k.m.Unlock()
+		return
+	}
+
+	k.waitingPong = false
+	k.latency = now.Sub(k.lastPing)
+
+	rtt, onLatency := k.latency, k.onLatency
+	k.m.Unlock()
+
+	if onLatency != nil {
+		go onLatency(rtt)
+	}
+}
+
+func (k *keepAlive) getLatency() time.Duration {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	return k.latency
+}
+
+func (k *keepAlive) setOnLatency(fn func(rtt time.Duration)) {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	k.onLatency = fn
+}
+
+// check returns a non-nil error if the peer is considered dead, otherwise it reports whether a ping is due
+func (k *keepAlive) check(now time.Time, opts *clientOptions) (sendPing bool, err *TimeoutError) {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	if opts.idleTimeout > 0 && now.Sub(k.lastActivity) > opts.idleTimeout {
+		return false, &TimeoutError{Reason: TimeoutReasonIdle, Timeout: opts.idleTimeout, LastActivity: k.lastActivity}
+	}
+
+	if opts.pingInterval <= 0 {
+		return false, nil
+	}
+
+	if k.waitingPong {
+		if now.Sub(k.lastPing) > opts.pongTimeout {
+			return false, &TimeoutError{Reason: TimeoutReasonPong, Timeout: opts.pongTimeout, LastActivity: k.lastActivity}
+		}
+		return false, nil
+	}
+
+	return now.Sub(k.lastPing) >= opts.pingInterval, nil
+}
+
+func (k *keepAlive) pinged(now time.Time) string {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	k.lastPing = now
+	k.waitingPong = true
+	k.pingPayload = strconv.FormatInt(now.UnixNano(), 10)
+
+	return k.pingPayload
+}
+
+// keepAliveTick is how often the client checks its deadlines, it is half of the shortest configured duration
+func (o *clientOptions) keepAliveTick() time.Duration {
+	var tick time.Duration
+	for _, d := range []time.Duration{o.pingInterval, o.pongTimeout, o.idleTimeout} {
+		if d > 0 && (tick == 0 || d < tick) {
+			tick = d
+		}
+	}
+
+	if tick /= 2; tick <= 0 {
+		tick = time.Millisecond
+	}
+
+	return tick
+}
+
+// Latency returns the round-trip time measured by the last ping/pong exchange
+// It's zero until keepalive is enabled and the first pong arrives
+func (c *Client) Latency() time.Duration {
+	return c.keepAlive.getLatency()
+}
+
+// SetOnLatency registers a callback receiving every round-trip measurement
+func (c *Client) SetOnLatency(fn func(rtt time.Duration)) *Client {
+	c.keepAlive.setOnLatency(fn)
+
+	return c
+}
+
+// runKeepAlive checks the keepalive deadlines on every tick, the ticker is created by the caller
+// so a fake clock already knows about it when NewClient returns
+func (c *Client) runKeepAlive(ticker Ticker) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case now := <-ticker.C():
+			sendPing, timeoutErr := c.keepAlive.check(now, c.opts)
+			if timeoutErr != nil {
+				c.logger.Warn("Keepalive timeout", "error", timeoutErr)
+				c.closeWithError(websocket.CloseGoingAway, timeoutErr.Reason, timeoutErr)
+				return
+			}
+
+			if !sendPing {
+				continue
+			}
+
+			payload := c.keepAlive.pinged(now)
+			// The write deadline is on the real clock, now may come from a fake one
+			err := c.ws.WriteControl(websocket.PingMessage, []byte(payload), time.Now().Add(c.opts.pongTimeout))
+			if err != nil {
+				c.logger.Error("Error sending ping", "error", err)
+			}
+		}
+	}
+}
@@ -1,14 +1,224 @@
 package socketify
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
+// Logger is a leveled, structured logger
+// keyvals are alternating keys and values, e.g. logger.Error("Error writing update", "error", err, "update_type", "pong")
 type Logger interface {
-	Error(args ...interface{})
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
 }
 
-type logger struct {
+// contextLogger is implemented by loggers that can carry fields themselves (e.g. the slog adapter)
+// Other loggers are wrapped by WithFields
+type contextLogger interface {
+	With(keyvals ...interface{}) Logger
 }
 
-func (logger) Error(args ...interface{}) {
-	fmt.Println(args...)
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	}
+
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// NewLogger returns a logger writing logfmt lines of level and above to w
+// e.g. time=2023-01-02T15:04:05Z level=error msg="Error reading message" connection_id=abc remote_addr=127.0.0.1:5000
+func NewLogger(w io.Writer, level LogLevel) Logger {
+	return &textLogger{w: w, level: level}
+}
+
+func defaultLogger() Logger {
+	return NewLogger(os.Stdout, LogLevelInfo)
+}
+
+type textLogger struct {
+	m     sync.Mutex
+	w     io.Writer
+	level LogLevel
+}
+
+func (l *textLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LogLevelDebug, msg, keyvals)
+}
+
+func (l *textLogger) Info(msg string, keyvals ...interface{}) {
+	l.log(LogLevelInfo, msg, keyvals)
+}
+
+func (l *textLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LogLevelWarn, msg, keyvals)
+}
+
+func (l *textLogger) Error(msg string, keyvals ...interface{}) {
+	l.log(LogLevelError, msg, keyvals)
+}
+
+func (l *textLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	var b strings.Builder
+
+	b.WriteString("time=")
+	b.WriteString(time.Now().Format(time.RFC3339))
+	b.WriteString(" level=")
+	b.WriteString(level.String())
+	b.WriteString(" msg=")
+	b.WriteString(logfmtValue(msg))
+
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+
+		b.WriteByte(' ')
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(fmt.Sprint(value)))
+	}
+
+	b.WriteByte('\n')
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	_, _ = io.WriteString(l.w, b.String())
+}
+
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+// NopLogger returns a logger that discards everything
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// LegacyLogger adapts a logger implementing the previous Logger interface, Error(args ...interface{})
+// Every level is passed to its Error method as the message followed by the key-value pairs
+func LegacyLogger(l interface{ Error(args ...interface{}) }) Logger {
+	return legacyLogger{l: l}
+}
+
+type legacyLogger struct {
+	l interface{ Error(args ...interface{}) }
+}
+
+func (l legacyLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LogLevelDebug, msg, keyvals)
+}
+
+func (l legacyLogger) Info(msg string, keyvals ...interface{}) {
+	l.log(LogLevelInfo, msg, keyvals)
+}
+
+func (l legacyLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LogLevelWarn, msg, keyvals)
+}
+
+func (l legacyLogger) Error(msg string, keyvals ...interface{}) {
+	l.log(LogLevelError, msg, keyvals)
+}
+
+func (l legacyLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	args := make([]interface{}, 0, len(keyvals)/2+2)
+	args = append(args, strings.ToUpper(level.String()), msg)
+
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			args = append(args, fmt.Sprintf("%v=%v", keyvals[i], keyvals[i+1]))
+		} else {
+			args = append(args, fmt.Sprintf("%v=(MISSING)", keyvals[i]))
+		}
+	}
+
+	l.l.Error(args...)
+}
+
+// WithFields returns a logger that adds keyvals to every line logged with it
+func WithFields(l Logger, keyvals ...interface{}) Logger {
+	if len(keyvals) == 0 {
+		return l
+	}
+
+	if cl, ok := l.(contextLogger); ok {
+		return cl.With(keyvals...)
+	}
+
+	if fl, ok := l.(fieldsLogger); ok {
+		return fieldsLogger{
+			l:      fl.l,
+			fields: append(append([]interface{}(nil), fl.fields...), keyvals...),
+		}
+	}
+
+	return fieldsLogger{l: l, fields: keyvals}
+}
+
+type fieldsLogger struct {
+	l      Logger
+	fields []interface{}
+}
+
+func (l fieldsLogger) with(keyvals []interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(l.fields)+len(keyvals)), l.fields...), keyvals...)
+}
+
+func (l fieldsLogger) Debug(msg string, keyvals ...interface{}) {
+	l.l.Debug(msg, l.with(keyvals)...)
+}
+
+func (l fieldsLogger) Info(msg string, keyvals ...interface{}) {
+	l.l.Info(msg, l.with(keyvals)...)
+}
+
+func (l fieldsLogger) Warn(msg string, keyvals ...interface{}) {
+	l.l.Warn(msg, l.with(keyvals)...)
+}
+
+func (l fieldsLogger) Error(msg string, keyvals ...interface{}) {
+	l.l.Error(msg, l.with(keyvals)...)
 }
@@ -0,0 +1,41 @@
+//go:build go1.21
+
+package socketify
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger adapts a *slog.Logger, a nil logger uses slog.Default()
+func SlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+
+	return slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l slogLogger) Debug(msg string, keyvals ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelDebug, msg, keyvals...)
+}
+
+func (l slogLogger) Info(msg string, keyvals ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelInfo, msg, keyvals...)
+}
+
+func (l slogLogger) Warn(msg string, keyvals ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelWarn, msg, keyvals...)
+}
+
+func (l slogLogger) Error(msg string, keyvals ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelError, msg, keyvals...)
+}
+
+func (l slogLogger) With(keyvals ...interface{}) Logger {
+	return slogLogger{l: l.l.With(keyvals...)}
+}
@@ -0,0 +1,118 @@
+//go:build go1.21
+
+package socketify_test
+
+import (
+	"context"
+	"github.com/aliforever/go-socketify"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+type capturedRecord struct {
+	level slog.Level
+	msg   string
+	attrs map[string]interface{}
+}
+
+// captureHandler is a slog.Handler that keeps every record with the attributes added by With
+type captureHandler struct {
+	m       *sync.Mutex
+	records *[]capturedRecord
+	attrs   []slog.Attr
+}
+
+func newCaptureHandler() *captureHandler {
+	return &captureHandler{m: &sync.Mutex{}, records: &[]capturedRecord{}}
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	record := capturedRecord{level: r.Level, msg: r.Message, attrs: map[string]interface{}{}}
+
+	for _, attr := range h.attrs {
+		record.attrs[attr.Key] = attr.Value.Any()
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		record.attrs[attr.Key] = attr.Value.Any()
+		return true
+	})
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	*h.records = append(*h.records, record)
+
+	return nil
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &captureHandler{m: h.m, records: h.records, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+func (h *captureHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func TestSlogLogger(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(l socketify.Logger)
+		want capturedRecord
+	}{
+		{
+			name: "Debug",
+			log: func(l socketify.Logger) {
+				l.Debug("reading", "update_type", "ping")
+			},
+			want: capturedRecord{level: slog.LevelDebug, msg: "reading", attrs: map[string]interface{}{"update_type": "ping"}},
+		},
+		{
+			name: "Info",
+			log: func(l socketify.Logger) {
+				l.Info("connected", "remote_addr", "127.0.0.1:5000")
+			},
+			want: capturedRecord{level: slog.LevelInfo, msg: "connected", attrs: map[string]interface{}{"remote_addr": "127.0.0.1:5000"}},
+		},
+		{
+			name: "Warn",
+			log: func(l socketify.Logger) {
+				l.Warn("rate limit exceeded", "tokens", 0)
+			},
+			want: capturedRecord{level: slog.LevelWarn, msg: "rate limit exceeded", attrs: map[string]interface{}{"tokens": int64(0)}},
+		},
+		{
+			name: "Error",
+			log: func(l socketify.Logger) {
+				l.Error("Error reading message", "error", "unexpected EOF", "size", 12)
+			},
+			want: capturedRecord{level: slog.LevelError, msg: "Error reading message", attrs: map[string]interface{}{"error": "unexpected EOF", "size": int64(12)}},
+		},
+		{
+			name: "WithFields",
+			log: func(l socketify.Logger) {
+				l = socketify.WithFields(l, "connection_id", "abc")
+				l = socketify.WithFields(l, "remote_addr", "127.0.0.1:5000")
+				l.Info("connected", "update_type", "ping")
+			},
+			want: capturedRecord{level: slog.LevelInfo, msg: "connected", attrs: map[string]interface{}{
+				"connection_id": "abc",
+				"remote_addr":   "127.0.0.1:5000",
+				"update_type":   "ping",
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newCaptureHandler()
+			tt.log(socketify.SlogLogger(slog.New(handler)))
+
+			assert.Equal(t, []capturedRecord{tt.want}, *handler.records)
+		})
+	}
+}
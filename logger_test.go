@@ -0,0 +1,88 @@
+package socketify_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aliforever/go-socketify"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+type legacyLogger struct {
+	lines []string
+}
+
+func (l *legacyLogger) Error(args ...interface{}) {
+	l.lines = append(l.lines, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		name  string
+		log   func(l socketify.Logger)
+		level socketify.LogLevel
+		want  []string
+	}{
+		{
+			name: "FiltersLevels",
+			log: func(l socketify.Logger) {
+				l.Debug("hidden")
+				l.Warn("shown", "key", "value")
+			},
+			level: socketify.LogLevelInfo,
+			want:  []string{`level=warn msg=shown key=value`},
+		},
+		{
+			name: "QuotesValues",
+			log: func(l socketify.Logger) {
+				l.Error("Error reading message", "error", "unexpected EOF")
+			},
+			level: socketify.LogLevelDebug,
+			want:  []string{`level=error msg="Error reading message" error="unexpected EOF"`},
+		},
+		{
+			name: "WithFields",
+			log: func(l socketify.Logger) {
+				l = socketify.WithFields(l, "connection_id", "abc")
+				l = socketify.WithFields(l, "remote_addr", "127.0.0.1:5000")
+				l.Info("connected", "update_type", "ping")
+			},
+			level: socketify.LogLevelDebug,
+			want:  []string{`level=info msg=connected connection_id=abc remote_addr=127.0.0.1:5000 update_type=ping`},
+		},
+		{
+			name: "MissingValue",
+			log: func(l socketify.Logger) {
+				l.Info("odd", "key")
+			},
+			level: socketify.LogLevelDebug,
+			want:  []string{`level=info msg=odd key=(MISSING)`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			test.log(socketify.NewLogger(&buf, test.level))
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			assert.Len(t, lines, len(test.want))
+
+			for i := range test.want {
+				// Strip the timestamp
+				_, line, _ := strings.Cut(lines[i], " ")
+				assert.Equal(t, test.want[i], line)
+			}
+		})
+	}
+}
+
+func TestLegacyLogger(t *testing.T) {
+	legacy := &legacyLogger{}
+
+	l := socketify.WithFields(socketify.LegacyLogger(legacy), "connection_id", "abc")
+	l.Error("Error reading message", "error", "EOF")
+
+	assert.Equal(t, []string{"ERROR Error reading message connection_id=abc error=EOF"}, legacy.lines)
+}
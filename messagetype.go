@@ -4,4 +4,6 @@ type messageType interface {
 	Type() int
 	Data() ([]byte, error)
 	Err() chan error
+	frame() *OutgoingFrame
+	applyFrame(frame *OutgoingFrame) error
 }
@@ -19,6 +19,15 @@ func (m *messageTypeBinary) Err() chan error {
 	return m.err
 }
 
+func (m *messageTypeBinary) frame() *OutgoingFrame {
+	return &OutgoingFrame{MessageType: m.Type(), Data: m.data}
+}
+
+func (m *messageTypeBinary) applyFrame(frame *OutgoingFrame) (err error) {
+	m.data, err = frameBytes(frame)
+	return
+}
+
 func newBinaryMessage(data []byte) *messageTypeBinary {
 	return &messageTypeBinary{data: data, err: make(chan error)}
 }
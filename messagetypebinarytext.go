@@ -19,6 +19,15 @@ func (m *messageTypeBinaryText) Err() chan error {
 	return m.err
 }
 
+func (m *messageTypeBinaryText) frame() *OutgoingFrame {
+	return &OutgoingFrame{MessageType: m.Type(), Data: m.data}
+}
+
+func (m *messageTypeBinaryText) applyFrame(frame *OutgoingFrame) (err error) {
+	m.data, err = frameBytes(frame)
+	return
+}
+
 func newBinaryTextMessage(data []byte) *messageTypeBinaryText {
 	return &messageTypeBinaryText{data: data, err: make(chan error)}
 }
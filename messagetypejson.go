@@ -22,6 +22,34 @@ func (m *messageTypeJSON) Err() chan error {
 	return m.err
 }
 
+func (m *messageTypeJSON) frame() *OutgoingFrame {
+	if su, ok := m.data.(serverUpdate); ok {
+		return &OutgoingFrame{
+			MessageType: m.Type(),
+			UpdateType:  su.Type,
+			Extra:       su.Extra,
+			Meta:        su.Meta,
+			Data:        su.Data,
+		}
+	}
+
+	return &OutgoingFrame{MessageType: m.Type(), Data: m.data}
+}
+
+func (m *messageTypeJSON) applyFrame(frame *OutgoingFrame) error {
+	if su, ok := m.data.(serverUpdate); ok {
+		su.Type = frame.UpdateType
+		su.Extra = frame.Extra
+		su.Meta = frame.Meta
+		su.Data = frame.Data
+		m.data = su
+		return nil
+	}
+
+	m.data = frame.Data
+	return nil
+}
+
 func newJSONMessage(data any) *messageTypeJSON {
 	return &messageTypeJSON{data: data, err: make(chan error)}
 }
@@ -19,6 +19,20 @@ func (m *messageTypeText) Err() chan error {
 	return m.err
 }
 
+func (m *messageTypeText) frame() *OutgoingFrame {
+	return &OutgoingFrame{MessageType: m.Type(), Data: m.data}
+}
+
+func (m *messageTypeText) applyFrame(frame *OutgoingFrame) error {
+	data, err := frameBytes(frame)
+	if err != nil {
+		return err
+	}
+
+	m.data = string(data)
+	return nil
+}
+
 func newTextMessage(data string) *messageTypeText {
 	return &messageTypeText{data: data, err: make(chan error)}
 }
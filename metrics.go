@@ -0,0 +1,300 @@
+package socketify
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxMetricsUpdateTypes caps the distinct update_type label values, update types come from clients
+// so every type after the cap is counted as "other"
+const maxMetricsUpdateTypes = 256
+
+const (
+	metricsUpdateTypeOther   = "other"
+	metricsUpdateTypeUnknown = "unknown"
+)
+
+// defaultLatencyBuckets are the upper bounds of the handler latency histogram in seconds
+var defaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metrics holds the counters of a server, every method is a no-op on a nil *metrics so call sites don't need to check
+// whether metrics are enabled
+type metrics struct {
+	connectionsActive int64
+	writeQueueDepth   int64
+	bytesReceived     uint64
+	bytesSent         uint64
+
+	m                sync.Mutex
+	upgrades         map[[2]string]uint64
+	closes           map[string]uint64
+	messagesReceived map[string]uint64
+	messagesSent     map[string]uint64
+	handlerLatency   map[string]*histogram
+	updateTypes      map[string]struct{}
+}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		upgrades:         map[[2]string]uint64{},
+		closes:           map[string]uint64{},
+		messagesReceived: map[string]uint64{},
+		messagesSent:     map[string]uint64{},
+		handlerLatency:   map[string]*histogram{},
+		updateTypes:      map[string]struct{}{},
+	}
+}
+
+// label returns the update_type label for updateType, the caller must hold m.m
+func (m *metrics) label(updateType string) string {
+	if updateType == "" {
+		return metricsUpdateTypeUnknown
+	}
+
+	if _, ok := m.updateTypes[updateType]; ok {
+		return updateType
+	}
+
+	if len(m.updateTypes) >= maxMetricsUpdateTypes {
+		return metricsUpdateTypeOther
+	}
+
+	m.updateTypes[updateType] = struct{}{}
+	return updateType
+}
+
+func (m *metrics) upgradeAccepted() {
+	if m == nil {
+		return
+	}
+
+	m.m.Lock()
+	m.upgrades[[2]string{"accepted", ""}]++
+	m.m.Unlock()
+}
+
+func (m *metrics) upgradeRejected(reason string) {
+	if m == nil {
+		return
+	}
+
+	m.m.Lock()
+	m.upgrades[[2]string{"rejected", reason}]++
+	m.m.Unlock()
+}
+
+func (m *metrics) connectionOpened() {
+	if m == nil {
+		return
+	}
+
+	atomic.AddInt64(&m.connectionsActive, 1)
+}
+
+func (m *metrics) connectionClosed(cause error) {
+	if m == nil {
+		return
+	}
+
+	atomic.AddInt64(&m.connectionsActive, -1)
+
+	code := "none"
+	var closeErr *websocket.CloseError
+	if errors.As(cause, &closeErr) {
+		code = strconv.Itoa(closeErr.Code)
+	}
+
+	m.m.Lock()
+	m.closes[code]++
+	m.m.Unlock()
+}
+
+func (m *metrics) messageReceived(updateType string, size int) {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.bytesReceived, uint64(size))
+
+	m.m.Lock()
+	m.messagesReceived[m.label(updateType)]++
+	m.m.Unlock()
+}
+
+func (m *metrics) messageSent(updateType string, size int) {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.bytesSent, uint64(size))
+
+	m.m.Lock()
+	m.messagesSent[m.label(updateType)]++
+	m.m.Unlock()
+}
+
+func (m *metrics) observeHandler(updateType string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	seconds := duration.Seconds()
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	label := m.label(updateType)
+
+	h := m.handlerLatency[label]
+	if h == nil {
+		h = &histogram{buckets: make([]uint64, len(defaultLatencyBuckets))}
+		m.handlerLatency[label] = h
+	}
+
+	for i, bound := range defaultLatencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *metrics) writeQueued(delta int64) {
+	if m == nil {
+		return
+	}
+
+	atomic.AddInt64(&m.writeQueueDepth, delta)
+}
+
+// writeTo writes the metrics in the Prometheus text exposition format
+func (m *metrics) writeTo(w io.Writer) {
+	var b strings.Builder
+
+	header := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("socketify_connections_active", "gauge", "Number of open connections")
+	fmt.Fprintf(&b, "socketify_connections_active %d\n", atomic.LoadInt64(&m.connectionsActive))
+
+	header("socketify_write_queue_depth", "gauge", "Number of write calls blocked waiting for a connection's writer or writing, writes aren't buffered")
+	fmt.Fprintf(&b, "socketify_write_queue_depth %d\n", atomic.LoadInt64(&m.writeQueueDepth))
+
+	header("socketify_received_bytes_total", "counter", "Bytes of messages received from clients")
+	fmt.Fprintf(&b, "socketify_received_bytes_total %d\n", atomic.LoadUint64(&m.bytesReceived))
+
+	header("socketify_sent_bytes_total", "counter", "Bytes of messages sent to clients")
+	fmt.Fprintf(&b, "socketify_sent_bytes_total %d\n", atomic.LoadUint64(&m.bytesSent))
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	header("socketify_upgrades_total", "counter", "Upgrade requests by result")
+	upgrades := make([][2]string, 0, len(m.upgrades))
+	for key := range m.upgrades {
+		upgrades = append(upgrades, key)
+	}
+	sort.Slice(upgrades, func(i, j int) bool {
+		return upgrades[i][0]+"\x00"+upgrades[i][1] < upgrades[j][0]+"\x00"+upgrades[j][1]
+	})
+	for _, key := range upgrades {
+		if key[1] == "" {
+			fmt.Fprintf(&b, "socketify_upgrades_total{result=%s} %d\n", quoteLabel(key[0]), m.upgrades[key])
+		} else {
+			fmt.Fprintf(&b, "socketify_upgrades_total{result=%s,reason=%s} %d\n", quoteLabel(key[0]), quoteLabel(key[1]), m.upgrades[key])
+		}
+	}
+
+	header("socketify_connections_closed_total", "counter", "Closed connections by close code, none when no close frame was exchanged")
+	writeCounters(&b, "socketify_connections_closed_total", "code", m.closes)
+
+	header("socketify_messages_received_total", "counter", "Messages received by update type")
+	writeCounters(&b, "socketify_messages_received_total", "update_type", m.messagesReceived)
+
+	header("socketify_messages_sent_total", "counter", "Messages sent by update type")
+	writeCounters(&b, "socketify_messages_sent_total", "update_type", m.messagesSent)
+
+	header("socketify_handler_duration_seconds", "histogram", "Time spent in middlewares and handlers by update type")
+	for _, updateType := range sortedKeys(m.handlerLatency) {
+		h := m.handlerLatency[updateType]
+		label := quoteLabel(updateType)
+
+		for i, bound := range defaultLatencyBuckets {
+			fmt.Fprintf(&b, "socketify_handler_duration_seconds_bucket{update_type=%s,le=%s} %d\n",
+				label, quoteLabel(formatFloat(bound)), h.buckets[i])
+		}
+		fmt.Fprintf(&b, "socketify_handler_duration_seconds_bucket{update_type=%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(&b, "socketify_handler_duration_seconds_sum{update_type=%s} %s\n", label, formatFloat(h.sum))
+		fmt.Fprintf(&b, "socketify_handler_duration_seconds_count{update_type=%s} %d\n", label, h.count)
+	}
+
+	_, _ = io.WriteString(w, b.String())
+}
+
+func writeCounters(b *strings.Builder, name, labelName string, values map[string]uint64) {
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(b, "%s{%s=%s} %d\n", name, labelName, quoteLabel(key), values[key])
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func quoteLabel(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return `"` + value + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// MetricsHandler returns an http.Handler serving the server's metrics in the Prometheus text format
+// Mount it on the mux passed to options.SetServeMux, e.g. mux.Handle("/metrics", server.MetricsHandler())
+// It responds with 404 unless metrics are enabled with options.EnableMetrics
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.metrics == nil {
+			http.Error(w, "metrics_disabled", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.metrics.writeTo(w)
+	})
+}
+
+// outgoingUpdateType returns the update type of messages written with WriteUpdate
+func outgoingUpdateType(message messageType) string {
+	if m, ok := message.(*messageTypeJSON); ok {
+		if su, ok := m.data.(serverUpdate); ok {
+			return su.Type
+		}
+	}
+
+	return ""
+}
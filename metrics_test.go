@@ -0,0 +1,109 @@
+package socketify
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWriteTo(t *testing.T) {
+	m := newMetrics()
+
+	m.upgradeAccepted()
+	m.upgradeRejected("connection_limit")
+	m.connectionOpened()
+	m.connectionOpened()
+	m.connectionClosed(&websocket.CloseError{Code: websocket.CloseNormalClosure})
+	m.messageReceived("ping", 16)
+	m.messageReceived("", 7)
+	m.messageSent("pong", 15)
+	m.observeHandler("ping", 3*time.Millisecond)
+
+	var buf bytes.Buffer
+	m.writeTo(&buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		`socketify_connections_active 1`,
+		`socketify_received_bytes_total 23`,
+		`socketify_sent_bytes_total 15`,
+		`socketify_upgrades_total{result="accepted"} 1`,
+		`socketify_upgrades_total{result="rejected",reason="connection_limit"} 1`,
+		`socketify_connections_closed_total{code="1000"} 1`,
+		`socketify_messages_received_total{update_type="ping"} 1`,
+		`socketify_messages_received_total{update_type="unknown"} 1`,
+		`socketify_messages_sent_total{update_type="pong"} 1`,
+		`socketify_handler_duration_seconds_bucket{update_type="ping",le="0.0025"} 0`,
+		`socketify_handler_duration_seconds_bucket{update_type="ping",le="0.005"} 1`,
+		`socketify_handler_duration_seconds_bucket{update_type="ping",le="+Inf"} 1`,
+		`socketify_handler_duration_seconds_count{update_type="ping"} 1`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+}
+
+func TestMetricsUpdateTypeCardinality(t *testing.T) {
+	m := newMetrics()
+
+	for i := 0; i < maxMetricsUpdateTypes+10; i++ {
+		m.messageReceived(fmt.Sprintf("type_%d", i), 1)
+	}
+
+	var buf bytes.Buffer
+	m.writeTo(&buf)
+
+	assert.Equal(t, maxMetricsUpdateTypes+1, strings.Count(buf.String(), "socketify_messages_received_total{"))
+	assert.Contains(t, buf.String(), `socketify_messages_received_total{update_type="other"} 10`+"\n")
+}
+
+func TestMetricsNilSafe(t *testing.T) {
+	var m *metrics
+
+	assert.NotPanics(t, func() {
+		m.upgradeAccepted()
+		m.upgradeRejected("connection_limit")
+		m.connectionOpened()
+		m.connectionClosed(nil)
+		m.messageReceived("ping", 16)
+		m.messageSent("pong", 15)
+		m.observeHandler("ping", time.Millisecond)
+		m.writeQueued(1)
+	})
+}
+
+func TestMetricsWriteQueueDepth(t *testing.T) {
+	ch := make(chan messageType)
+	done := make(chan struct{})
+	defer close(done)
+
+	w := newWriter(ch, done, NopLogger())
+	w.metrics = newMetrics()
+
+	written := make(chan error, 1)
+	go func() {
+		written <- w.write(newTextMessage("hello"))
+	}()
+
+	depth := func() string {
+		var buf bytes.Buffer
+		w.metrics.writeTo(&buf)
+		return buf.String()
+	}
+
+	// Blocked until the writer receives it
+	assert.Eventually(t, func() bool {
+		return strings.Contains(depth(), "socketify_write_queue_depth 1\n")
+	}, time.Second, time.Millisecond*10)
+
+	// Still counted while it's being written
+	m := <-ch
+	assert.Contains(t, depth(), "socketify_write_queue_depth 1\n")
+
+	m.Err() <- nil
+	assert.NoError(t, <-written)
+	assert.Contains(t, depth(), "socketify_write_queue_depth 0\n")
+}
@@ -0,0 +1,96 @@
+package socketify
+
+import "context"
+
+// UpdateHandler handles a decoded update
+type UpdateHandler func(ctx context.Context, update *Update) error
+
+// Middleware wraps the next UpdateHandler in the chain
+// A middleware can rewrite the update before calling next, do work around it (timing, recovery, transactions...)
+// or short-circuit the chain by writing a response itself and returning without calling next
+type Middleware func(next UpdateHandler) UpdateHandler
+
+type connectionContextKey struct{}
+
+// ConnectionFromContext returns the connection an update was received on
+// It's available in middlewares and in DataMapperContext handlers
+func ConnectionFromContext(ctx context.Context) (*Connection, bool) {
+	c, ok := ctx.Value(connectionContextKey{}).(*Connection)
+	return c, ok
+}
+
+func chainMiddlewares(handler UpdateHandler, middlewares ...Middleware) UpdateHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// Use appends middlewares to the connection's chain, they run after the server's middlewares
+func (c *Connection) Use(middlewares ...Middleware) {
+	c.handlersLocker.Lock()
+	defer c.handlersLocker.Unlock()
+
+	c.middlewares = append(c.middlewares, middlewares...)
+	c.buildChains()
+}
+
+// UseForUpdate appends middlewares that only run for updateType, after the connection's middlewares
+func (c *Connection) UseForUpdate(updateType string, middlewares ...Middleware) {
+	c.handlersLocker.Lock()
+	defer c.handlersLocker.Unlock()
+
+	c.updateTypeMiddlewares[updateType] = append(c.updateTypeMiddlewares[updateType], middlewares...)
+	c.buildChains()
+}
+
+// buildChains builds the chain of every update type when middlewares are registered, in this order:
+// server middlewares, server updateType middlewares, connection middlewares, connection updateType middlewares, handler
+// Update types without their own middlewares share the default chain
+// The handler is looked up by the type of the update that reaches it, so middlewares may reroute updates
+// handlersLocker must be held
+func (c *Connection) buildChains() {
+	opts := c.server.opts
+
+	chain := func(updateType string) UpdateHandler {
+		var middlewares []Middleware
+		middlewares = append(middlewares, opts.middlewares...)
+		middlewares = append(middlewares, opts.updateTypeMiddlewares[updateType]...)
+		middlewares = append(middlewares, c.middlewares...)
+		middlewares = append(middlewares, c.updateTypeMiddlewares[updateType]...)
+
+		return chainMiddlewares(c.handleUpdate, middlewares...)
+	}
+
+	c.chain = chain("")
+	c.updateTypeChains = map[string]UpdateHandler{}
+
+	for updateType := range opts.updateTypeMiddlewares {
+		c.updateTypeChains[updateType] = chain(updateType)
+	}
+	for updateType := range c.updateTypeMiddlewares {
+		c.updateTypeChains[updateType] = chain(updateType)
+	}
+}
+
+// updateHandler returns the chain built for updateType
+func (c *Connection) updateHandler(updateType string) UpdateHandler {
+	c.handlersLocker.Lock()
+	defer c.handlersLocker.Unlock()
+
+	if handler, ok := c.updateTypeChains[updateType]; ok {
+		return handler
+	}
+
+	return c.chain
+}
+
+func (c *Connection) handleUpdate(ctx context.Context, update *Update) error {
+	// Check if there's a default handler registered for the updateType and call it
+	if handler := c.getHandlerByType(update.Type); handler != nil {
+		return handler.Handle(ctx, update.Data, c.extraOf(update)...)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package socketify_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	var (
+		m     sync.Mutex
+		calls []string
+	)
+
+	record := func(name string) socketify.Middleware {
+		return func(next socketify.UpdateHandler) socketify.UpdateHandler {
+			return func(ctx context.Context, update *socketify.Update) error {
+				m.Lock()
+				calls = append(calls, name+":"+update.Type)
+				m.Unlock()
+
+				return next(ctx, update)
+			}
+		}
+	}
+
+	opts := socketify.ServerOptions().
+		SetLogger(socketify.NopLogger()).
+		Use(record("server")).
+		UseForUpdate("echo", record("server_echo"))
+
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(opts), func(c *socketify.Connection) {
+		c.Use(record("connection"))
+		c.UseForUpdate("echo", record("connection_echo"))
+		c.UseForUpdate("shout", func(next socketify.UpdateHandler) socketify.UpdateHandler {
+			return func(ctx context.Context, update *socketify.Update) error {
+				// Rewrites the update to an echo with upper case data
+				update.Type = "echo"
+				update.Data = json.RawMessage(`"HELLO"`)
+
+				return next(ctx, update)
+			}
+		})
+		c.UseForUpdate("blocked", func(next socketify.UpdateHandler) socketify.UpdateHandler {
+			return func(ctx context.Context, update *socketify.Update) error {
+				c.WriteUpdate("denied", update.Type)
+				return nil
+			}
+		})
+
+		echo := socketify.DataMapper[string](func(data string, _ ...string) error {
+			c.WriteUpdate("echo", data)
+			return nil
+		})
+		c.HandleUpdate("echo", echo)
+		c.HandleUpdate("blocked", echo)
+	})
+
+	tests := []struct {
+		name       string
+		updateType string
+		wantType   string
+		wantData   string
+		wantCalls  []string
+	}{
+		{
+			name:       "Order",
+			updateType: "echo",
+			wantType:   "echo",
+			wantData:   `"hello"`,
+			wantCalls:  []string{"server:echo", "server_echo:echo", "connection:echo", "connection_echo:echo"},
+		},
+		{
+			name:       "Rewrite",
+			updateType: "shout",
+			wantType:   "echo",
+			wantData:   `"HELLO"`,
+			wantCalls:  []string{"server:shout", "connection:shout"},
+		},
+		{
+			name:       "ShortCircuit",
+			updateType: "blocked",
+			wantType:   "denied",
+			wantData:   `"blocked"`,
+			wantCalls:  []string{"server:blocked", "connection:blocked"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.Lock()
+			calls = nil
+			m.Unlock()
+
+			got, _ := server.Pair()
+
+			got.SendUpdate(tt.updateType, "hello")
+
+			update := got.ExpectUpdate(tt.wantType, time.Second)
+			assert.JSONEq(t, tt.wantData, string(update.Data))
+			got.ExpectNoUpdate(time.Millisecond * 50)
+
+			m.Lock()
+			assert.Equal(t, tt.wantCalls, calls)
+			m.Unlock()
+		})
+	}
+}
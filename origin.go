@@ -0,0 +1,153 @@
+package socketify
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// originPattern is a parsed SetAllowedOrigins pattern: [scheme://]host[:port]
+// Each part can be "*", host can also start with "*." to match any subdomain
+type originPattern struct {
+	scheme string
+	host   string
+	port   string
+}
+
+func parseOriginPattern(pattern string) originPattern {
+	var p originPattern
+
+	rest := strings.ToLower(strings.TrimSpace(pattern))
+	if i := strings.Index(rest, "://"); i >= 0 {
+		p.scheme, rest = rest[:i], rest[i+3:]
+	} else {
+		p.scheme = "*"
+	}
+
+	rest = strings.TrimSuffix(rest, "/")
+
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.HasSuffix(rest, "]") {
+		p.host, p.port = rest[:i], rest[i+1:]
+	} else {
+		p.host = rest
+	}
+
+	p.host = strings.Trim(p.host, "[]")
+
+	return p
+}
+
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+
+	return ""
+}
+
+func (p originPattern) match(origin *url.URL) bool {
+	scheme := strings.ToLower(origin.Scheme)
+	host := strings.ToLower(origin.Hostname())
+
+	port := origin.Port()
+	if port == "" {
+		port = defaultPort(scheme)
+	}
+
+	if p.scheme != "*" && p.scheme != scheme {
+		return false
+	}
+
+	switch {
+	case p.host == "*":
+	case strings.HasPrefix(p.host, "*."):
+		if !strings.HasSuffix(host, p.host[1:]) {
+			return false
+		}
+	case p.host != host:
+		return false
+	}
+
+	switch p.port {
+	case "*":
+		return true
+	case "":
+		// Without a port in the pattern only the default port of the origin's scheme is allowed
+		return port == defaultPort(scheme)
+	default:
+		return p.port == port
+	}
+}
+
+func allowedOriginsChecker(patterns []string) func(r *http.Request) bool {
+	parsed := make([]originPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		parsed = append(parsed, parseOriginPattern(pattern))
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+
+		for _, pattern := range parsed {
+			if pattern.match(u) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// checkSameOrigin allows requests without an Origin header and requests whose Origin host matches the Host header
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host) || strings.EqualFold(withDefaultPort(u.Host, u.Scheme), withDefaultPort(r.Host, u.Scheme))
+}
+
+func withDefaultPort(host, scheme string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultPort(strings.ToLower(scheme)))
+}
+
+func (o *options) originChecker() func(r *http.Request) bool {
+	check := checkSameOrigin
+
+	if o.checkOrigin != nil {
+		check = o.checkOrigin
+	} else if len(o.allowedOrigins) > 0 {
+		check = allowedOriginsChecker(o.allowedOrigins)
+	}
+
+	return func(r *http.Request) bool {
+		if check(r) {
+			return true
+		}
+
+		o.logger.Warn("Origin rejected", "origin", r.Header.Get("Origin"), "host", r.Host, "remote_addr", r.RemoteAddr)
+		return false
+	}
+}
@@ -0,0 +1,71 @@
+package socketify
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestAllowedOrigins(t *testing.T) {
+	patterns := []string{"https://*.example.com", "http://localhost:*", "example.org", "wss://api.example.net:8443"}
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{name: "NoOrigin", origin: "", want: true},
+		{name: "Subdomain", origin: "https://app.example.com", want: true},
+		{name: "NestedSubdomain", origin: "https://a.b.example.com", want: true},
+		{name: "SubdomainDefaultPort", origin: "https://app.example.com:443", want: true},
+		{name: "ApexNotMatchedBySubdomainPattern", origin: "https://example.com", want: false},
+		{name: "WrongScheme", origin: "http://app.example.com", want: false},
+		{name: "NonDefaultPort", origin: "https://app.example.com:8443", want: false},
+		{name: "SuffixTrick", origin: "https://evilexample.com", want: false},
+		{name: "AnyPort", origin: "http://localhost:3000", want: true},
+		{name: "AnySchemeHttp", origin: "http://example.org", want: true},
+		{name: "AnySchemeHttps", origin: "https://example.org", want: true},
+		{name: "ExplicitPort", origin: "wss://api.example.net:8443", want: true},
+		{name: "Unlisted", origin: "https://attacker.io", want: false},
+		{name: "Invalid", origin: "null", want: false},
+	}
+
+	check := allowedOriginsChecker(patterns)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{Header: http.Header{}, Host: "ws.example.com"}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+
+			assert.Equal(t, tt.want, check(r))
+		})
+	}
+}
+
+func TestCheckSameOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   bool
+	}{
+		{name: "NoOrigin", host: "example.com", origin: "", want: true},
+		{name: "Same", host: "example.com", origin: "https://example.com", want: true},
+		{name: "SameExplicitDefaultPort", host: "example.com:443", origin: "https://example.com", want: true},
+		{name: "DifferentPort", host: "example.com:8080", origin: "https://example.com", want: false},
+		{name: "DifferentHost", host: "example.com", origin: "https://attacker.io", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{Header: http.Header{}, Host: tt.host}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+
+			assert.Equal(t, tt.want, checkSameOrigin(r))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package socketify
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is reported instead of crashing the process when a handler panics
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func newPanicError(value interface{}) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func (c *Connection) handlePanic(message []byte, update *Update, value interface{}) {
+	err := newPanicError(value)
+
+	var extra []string
+	if update != nil {
+		extra = c.extraOf(update)
+	}
+
+	c.logger.Error("Recovered from panic", "panic", value, "data", string(message), "stack", string(err.Stack))
+	c.reportError(ErrorCategoryPanic, message, update, err)
+
+	if reply := c.server.opts.panicReply; reply != nil {
+		if writeErr := c.WriteUpdate(reply.updateType, reply.data, extra...); writeErr != nil {
+			c.logger.Error("Error writing panic reply", "error", writeErr)
+		}
+		return
+	}
+
+	c.replyError(err, extra...)
+}
+
+// safe runs fn, panics are reported to the client's onError as a *PanicError
+func (c *Client) safe(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := newPanicError(r)
+			c.logger.Error("Recovered from panic", "panic", r, "stack", string(err.Stack))
+			c.handlerErr(err)
+		}
+	}()
+
+	fn()
+}
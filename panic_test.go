@@ -0,0 +1,158 @@
+package socketify_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestHandlerPanic(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    func() *socketify.Server
+		wantType  string
+		wantReply string
+	}{
+		{
+			name: "PanicReply",
+			server: func() *socketify.Server {
+				return socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetPanicReply("oops", map[string]string{"message": "try again"}))
+			},
+			wantType:  "oops",
+			wantReply: `{"message": "try again"}`,
+		},
+		{
+			name: "ErrorReply",
+			server: func() *socketify.Server {
+				return socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).EnableErrorReplies())
+			},
+			wantType:  "error",
+			wantReply: `{"code": "internal_error", "message": "internal error"}`,
+		},
+		{
+			name: "NoReply",
+			server: func() *socketify.Server {
+				return socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := make(chan socketify.UpdateError, 1)
+
+			server := socketifytest.NewPipeServer(t, tt.server(), func(c *socketify.Connection) {
+				c.OnError(func(ue socketify.UpdateError) {
+					errs <- ue
+				})
+				c.HandleUpdate("boom", socketify.DataMapper[socketify.EmptyInput](func(socketify.EmptyInput, ...string) error {
+					panic("boom")
+				}))
+				c.HandleUpdate("ping", socketify.DataMapper[socketify.EmptyInput](func(socketify.EmptyInput, ...string) error {
+					return c.WriteUpdate("pong", nil)
+				}))
+			})
+
+			got, connection := server.Pair()
+
+			got.SendUpdate("boom", nil, "1")
+
+			select {
+			case ue := <-errs:
+				var panicErr *socketify.PanicError
+				if assert.ErrorAs(t, ue.Error, &panicErr) {
+					assert.Equal(t, "boom", panicErr.Value)
+				}
+				assert.Equal(t, socketify.ErrorCategoryPanic, ue.Category)
+				assert.Equal(t, "boom", ue.UpdateType)
+				assert.NotEmpty(t, ue.Stack)
+			case <-time.After(time.Second):
+				t.Fatal("panic was not reported")
+			}
+
+			if tt.wantType != "" {
+				update := got.ExpectUpdate(tt.wantType, time.Second)
+				assert.JSONEq(t, tt.wantReply, string(update.Data))
+				assert.Equal(t, "1", update.Extra)
+			}
+
+			// The read loop survived the panic
+			got.SendUpdate("ping", nil)
+			got.ExpectUpdate("pong", time.Second)
+			assert.NoError(t, connection.Context().Err())
+		})
+	}
+}
+
+func TestClientHandlerPanic(t *testing.T) {
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())), nil)
+
+	got, connection := server.PairWith(func(url string, dialer *websocket.Dialer) (*socketify.Client, error) {
+		return socketify.NewClientWithOptions(url, socketify.ClientOptions().SetLogger(socketify.NopLogger()).SetDialer(dialer))
+	})
+
+	errs := make(chan error, 1)
+	pongs := make(chan struct{}, 1)
+
+	// Replaces the harness' raw handler so updates reach the update type handlers
+	got.SetRawHandler(nil)
+	got.SetOnError(func(err error) {
+		errs <- err
+	})
+	got.SetUpdateTypeHandler("boom", func(json.RawMessage) {
+		panic("boom")
+	})
+	got.SetUpdateTypeHandler("pong", func(json.RawMessage) {
+		pongs <- struct{}{}
+	})
+
+	assert.NoError(t, connection.WriteUpdate("boom", nil))
+
+	select {
+	case err := <-errs:
+		var panicErr *socketify.PanicError
+		assert.ErrorAs(t, err, &panicErr)
+	case <-time.After(time.Second):
+		t.Fatal("panic was not reported")
+	}
+
+	assert.NoError(t, connection.WriteUpdate("pong", nil))
+
+	select {
+	case <-pongs:
+	case <-time.After(time.Second):
+		t.Fatal("client stopped handling updates after a panic")
+	}
+}
+
+// TestDisablePanicRecovery runs itself in a subprocess because the panic crashes the process
+func TestDisablePanicRecovery(t *testing.T) {
+	if os.Getenv("SOCKETIFY_TEST_PANIC") == "1" {
+		server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).DisablePanicRecovery()), func(c *socketify.Connection) {
+			c.HandleUpdate("boom", socketify.DataMapper[socketify.EmptyInput](func(socketify.EmptyInput, ...string) error {
+				panic("boom")
+			}))
+		})
+
+		got, _ := server.Pair()
+		got.SendUpdate("boom", nil)
+		time.Sleep(time.Second * 5)
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDisablePanicRecovery$")
+	cmd.Env = append(os.Environ(), "SOCKETIFY_TEST_PANIC=1")
+	output, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	assert.True(t, errors.As(err, &exitErr), "the process didn't crash: %s", output)
+	assert.Contains(t, string(output), "panic: boom")
+}
@@ -0,0 +1,445 @@
+package socketify
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultPresenceTTL = time.Second * 30
+
+type PresenceEventType string
+
+const (
+	PresenceJoin  PresenceEventType = "join"
+	PresenceLeave PresenceEventType = "leave"
+)
+
+// Reasons of leave events
+const (
+	PresenceLeft    = "left"
+	PresenceExpired = "expired"
+)
+
+// PresenceEntry tells which instance holds a connection of a user
+type PresenceEntry struct {
+	UserID       string    `json:"user_id"`
+	ConnectionID string    `json:"connection_id"`
+	NodeID       string    `json:"node_id"`
+	JoinedAt     time.Time `json:"joined_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// PresenceEvent is sent to watchers when a connection joins or leaves, Reason is only set for leaves
+type PresenceEvent struct {
+	Type   PresenceEventType `json:"type"`
+	Entry  PresenceEntry     `json:"entry"`
+	Reason string            `json:"reason,omitempty"`
+}
+
+// PresenceBackend stores the presence entries of every instance, a Redis backend can implement it with
+// a hash per user, a sorted set of expiry times and pub/sub for the events
+type PresenceBackend interface {
+	Join(ctx context.Context, entry PresenceEntry) error
+	// Leave removes the entry of a connection, it's not an error if there is none
+	Leave(ctx context.Context, connectionID string) error
+	// Heartbeat extends entries until their ExpiresAt, entries that expired or were lost are added back and
+	// reported as joins
+	Heartbeat(ctx context.Context, entries []PresenceEntry) error
+	// Expire removes the entries that expired before now, they're reported as leaves with PresenceExpired
+	Expire(ctx context.Context, now time.Time) error
+	// Lookup returns the entries of a user, they may include expired entries that weren't removed yet
+	Lookup(ctx context.Context, userID string) ([]PresenceEntry, error)
+	// Watch calls handler for every join and leave of every instance until stop is called
+	Watch(handler func(event PresenceEvent)) (stop func(), err error)
+}
+
+// Presence tracks which users are connected to this instance in a backend shared by every instance
+// Entries of an instance expire after the TTL unless its heartbeat, started by Start, refreshes them
+type Presence struct {
+	server  *Server
+	backend PresenceBackend
+	ttl     time.Duration
+	clock   Clock
+
+	m       sync.Mutex
+	tracked map[string]*trackedConnection
+	stop    chan struct{}
+	done    chan struct{}
+
+	// syncM orders heartbeats and leaves, so a heartbeat doesn't add back an entry that was just removed
+	syncM sync.Mutex
+}
+
+type trackedConnection struct {
+	entry PresenceEntry
+	// joined is set once the backend has the entry, heartbeats only send joined entries
+	joined bool
+	// untracked stops the goroutine waiting for the connection to close
+	untracked chan struct{}
+}
+
+func NewPresence(server *Server, backend PresenceBackend) *Presence {
+	return &Presence{
+		server:  server,
+		backend: backend,
+		ttl:     defaultPresenceTTL,
+		clock:   realClock{},
+		tracked: map[string]*trackedConnection{},
+	}
+}
+
+// SetTTL sets how long entries live without a heartbeat, heartbeats are sent every third of it
+// It's 30 seconds by default
+func (p *Presence) SetTTL(ttl time.Duration) *Presence {
+	p.ttl = ttl
+	return p
+}
+
+// SetClock sets the time source of heartbeats and expiry, it's meant for tests
+func (p *Presence) SetClock(clock Clock) *Presence {
+	p.clock = clock
+	return p
+}
+
+// Track marks userID online on this instance until c is closed or Untrack is called
+// Tracking a connection again for the same user does nothing, for another user it replaces the entry
+func (p *Presence) Track(c Conn, userID string) error {
+	p.m.Lock()
+	existing := p.tracked[c.ID()]
+	p.m.Unlock()
+
+	if existing != nil {
+		if existing.entry.UserID == userID {
+			return nil
+		}
+
+		if err := p.Untrack(c.ID()); err != nil {
+			return err
+		}
+	}
+
+	now := p.clock.Now()
+	entry := PresenceEntry{
+		UserID:       userID,
+		ConnectionID: c.ID(),
+		NodeID:       p.server.NodeID(),
+		JoinedAt:     now,
+		ExpiresAt:    now.Add(p.ttl),
+	}
+	t := &trackedConnection{entry: entry, untracked: make(chan struct{})}
+
+	p.m.Lock()
+	if p.tracked[c.ID()] != nil {
+		// Tracked concurrently
+		p.m.Unlock()
+		return nil
+	}
+	p.tracked[c.ID()] = t
+	p.m.Unlock()
+
+	err := p.backend.Join(c.Context(), entry)
+
+	p.m.Lock()
+	current := p.tracked[c.ID()] == t
+	untracked := p.tracked[c.ID()] == nil
+	if current && err != nil {
+		delete(p.tracked, c.ID())
+		close(t.untracked)
+	}
+	t.joined = current && err == nil
+	p.m.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if untracked {
+		// Untracked while joining
+		return p.backend.Leave(context.Background(), c.ID())
+	}
+
+	if !current {
+		return nil
+	}
+
+	go func() {
+		select {
+		case <-c.Context().Done():
+			_ = p.Untrack(c.ID())
+		case <-t.untracked:
+		}
+	}()
+
+	return nil
+}
+
+// Untrack removes the entry of a connection
+func (p *Presence) Untrack(connectionID string) error {
+	p.syncM.Lock()
+	defer p.syncM.Unlock()
+
+	p.m.Lock()
+	t := p.tracked[connectionID]
+	delete(p.tracked, connectionID)
+	p.m.Unlock()
+
+	if t == nil {
+		return nil
+	}
+
+	close(t.untracked)
+
+	return p.backend.Leave(context.Background(), connectionID)
+}
+
+// Lookup returns the live entries of userID on every instance
+func (p *Presence) Lookup(ctx context.Context, userID string) ([]PresenceEntry, error) {
+	entries, err := p.backend.Lookup(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := p.clock.Now()
+
+	live := entries[:0]
+	for _, entry := range entries {
+		if entry.ExpiresAt.After(now) {
+			live = append(live, entry)
+		}
+	}
+
+	return live, nil
+}
+
+// IsOnline reports whether userID has a live connection on any instance
+func (p *Presence) IsOnline(ctx context.Context, userID string) (bool, error) {
+	entries, err := p.Lookup(ctx, userID)
+
+	return len(entries) > 0, err
+}
+
+// Watch calls handler for every join and leave of every instance until stop is called
+func (p *Presence) Watch(handler func(event PresenceEvent)) (stop func(), err error) {
+	return p.backend.Watch(handler)
+}
+
+// Start sends heartbeats for the connections of this instance and expires the entries of crashed instances
+func (p *Presence) Start() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.stop != nil {
+		return
+	}
+
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+
+	ticker := p.clock.NewTicker(p.ttl / 3)
+	go p.run(ticker, p.stop, p.done)
+}
+
+// Stop stops the heartbeats and removes the entries of this instance
+func (p *Presence) Stop() {
+	p.m.Lock()
+	stop, done := p.stop, p.done
+	p.stop, p.done = nil, nil
+
+	connectionIDs := make([]string, 0, len(p.tracked))
+	for connectionID := range p.tracked {
+		connectionIDs = append(connectionIDs, connectionID)
+	}
+	p.m.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	for _, connectionID := range connectionIDs {
+		_ = p.Untrack(connectionID)
+	}
+}
+
+func (p *Presence) run(ticker Ticker, stop, done chan struct{}) {
+	defer close(done)
+	defer ticker.Stop()
+
+	p.heartbeat(p.clock.Now())
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C():
+			p.heartbeat(now)
+		}
+	}
+}
+
+// heartbeat sends every tracked entry, so entries the backend expired while this instance was slow come back
+func (p *Presence) heartbeat(now time.Time) {
+	ctx := context.Background()
+
+	p.syncM.Lock()
+	p.m.Lock()
+	entries := make([]PresenceEntry, 0, len(p.tracked))
+	for _, t := range p.tracked {
+		if !t.joined {
+			continue
+		}
+		t.entry.ExpiresAt = now.Add(p.ttl)
+		entries = append(entries, t.entry)
+	}
+	p.m.Unlock()
+
+	err := p.backend.Heartbeat(ctx, entries)
+	p.syncM.Unlock()
+
+	if err != nil {
+		p.server.opts.logger.Error("Error sending presence heartbeat", "error", err)
+	}
+
+	if err := p.backend.Expire(ctx, now); err != nil {
+		p.server.opts.logger.Error("Error expiring presence entries", "error", err)
+	}
+}
+
+// MemoryPresence is a PresenceBackend for instances running in the same process, e.g. in tests
+type MemoryPresence struct {
+	m        sync.Mutex
+	entries  map[string]PresenceEntry
+	nextID   int
+	watchers map[int]func(event PresenceEvent)
+}
+
+func NewMemoryPresence() *MemoryPresence {
+	return &MemoryPresence{
+		entries:  map[string]PresenceEntry{},
+		watchers: map[int]func(event PresenceEvent){},
+	}
+}
+
+func (b *MemoryPresence) Join(_ context.Context, entry PresenceEntry) error {
+	b.m.Lock()
+	b.entries[entry.ConnectionID] = entry
+	b.m.Unlock()
+
+	b.notify(PresenceEvent{Type: PresenceJoin, Entry: entry})
+
+	return nil
+}
+
+func (b *MemoryPresence) Leave(_ context.Context, connectionID string) error {
+	b.m.Lock()
+	entry, exists := b.entries[connectionID]
+	delete(b.entries, connectionID)
+	b.m.Unlock()
+
+	if exists {
+		b.notify(PresenceEvent{Type: PresenceLeave, Entry: entry, Reason: PresenceLeft})
+	}
+
+	return nil
+}
+
+func (b *MemoryPresence) Heartbeat(_ context.Context, entries []PresenceEntry) error {
+	b.m.Lock()
+	var joined []PresenceEntry
+	for _, entry := range entries {
+		existing, exists := b.entries[entry.ConnectionID]
+		if !exists {
+			b.entries[entry.ConnectionID] = entry
+			joined = append(joined, entry)
+			continue
+		}
+
+		if entry.ExpiresAt.After(existing.ExpiresAt) {
+			existing.ExpiresAt = entry.ExpiresAt
+			b.entries[entry.ConnectionID] = existing
+		}
+	}
+	b.m.Unlock()
+
+	sort.Slice(joined, func(i, j int) bool {
+		return joined[i].ConnectionID < joined[j].ConnectionID
+	})
+
+	for _, entry := range joined {
+		b.notify(PresenceEvent{Type: PresenceJoin, Entry: entry})
+	}
+
+	return nil
+}
+
+func (b *MemoryPresence) Expire(_ context.Context, now time.Time) error {
+	b.m.Lock()
+	var expired []PresenceEntry
+	for connectionID, entry := range b.entries {
+		if !entry.ExpiresAt.After(now) {
+			expired = append(expired, entry)
+			delete(b.entries, connectionID)
+		}
+	}
+	b.m.Unlock()
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].ConnectionID < expired[j].ConnectionID
+	})
+
+	for _, entry := range expired {
+		b.notify(PresenceEvent{Type: PresenceLeave, Entry: entry, Reason: PresenceExpired})
+	}
+
+	return nil
+}
+
+func (b *MemoryPresence) Lookup(_ context.Context, userID string) ([]PresenceEntry, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var entries []PresenceEntry
+	for _, entry := range b.entries {
+		if entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].JoinedAt.Before(entries[j].JoinedAt)
+	})
+
+	return entries, nil
+}
+
+// Watch calls handler synchronously, on the goroutine that changed the presence
+func (b *MemoryPresence) Watch(handler func(event PresenceEvent)) (func(), error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.nextID++
+	id := b.nextID
+	b.watchers[id] = handler
+
+	return func() {
+		b.m.Lock()
+		defer b.m.Unlock()
+
+		delete(b.watchers, id)
+	}, nil
+}
+
+func (b *MemoryPresence) notify(event PresenceEvent) {
+	b.m.Lock()
+	watchers := make([]func(event PresenceEvent), 0, len(b.watchers))
+	for _, watcher := range b.watchers {
+		watchers = append(watchers, watcher)
+	}
+	b.m.Unlock()
+
+	for _, watcher := range watchers {
+		watcher(event)
+	}
+}
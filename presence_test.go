@@ -0,0 +1,174 @@
+package socketify_test
+
+import (
+	"context"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPresence(t *testing.T) {
+	start := time.Now()
+	clock := socketifytest.NewFakeClock(start)
+	backend := socketify.NewMemoryPresence()
+	ctx := context.Background()
+
+	presenceA := socketify.NewPresence(socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetNodeID("a")), backend).
+		SetTTL(time.Second * 30).
+		SetClock(clock)
+	presenceB := socketify.NewPresence(socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetNodeID("b")), backend).
+		SetTTL(time.Second * 30).
+		SetClock(clock)
+
+	events := presenceEvents(t, presenceB)
+
+	onA := socketifytest.NewFakeConn("1")
+	assert.NoError(t, presenceA.Track(onA, "ali"))
+	expectPresenceEvent(t, events, socketify.PresenceJoin, "1", "")
+
+	onB := socketifytest.NewFakeConn("2")
+	assert.NoError(t, presenceB.Track(onB, "sara"))
+	expectPresenceEvent(t, events, socketify.PresenceJoin, "2", "")
+
+	entries, err := presenceB.Lookup(ctx, "ali")
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "a", entries[0].NodeID)
+	}
+
+	t.Run("Closed", func(t *testing.T) {
+		closing := socketifytest.NewFakeConn("3")
+		assert.NoError(t, presenceB.Track(closing, "sara"))
+		expectPresenceEvent(t, events, socketify.PresenceJoin, "3", "")
+
+		assert.NoError(t, closing.Close())
+		expectPresenceEvent(t, events, socketify.PresenceLeave, "3", socketify.PresenceLeft)
+	})
+
+	t.Run("CrashedNode", func(t *testing.T) {
+		// Only b sends heartbeats, a's entries expire after the TTL
+		presenceB.Start()
+		defer presenceB.Stop()
+
+		clock.Advance(time.Second * 10)
+		assert.Eventually(t, func() bool {
+			entries, _ := backend.Lookup(ctx, "sara")
+			return len(entries) == 1 && entries[0].ExpiresAt.Equal(start.Add(time.Second*40))
+		}, time.Second, time.Millisecond*10)
+
+		clock.Advance(time.Second * 25)
+		expectPresenceEvent(t, events, socketify.PresenceLeave, "1", socketify.PresenceExpired)
+
+		online, err := presenceB.IsOnline(ctx, "ali")
+		assert.NoError(t, err)
+		assert.False(t, online)
+
+		online, err = presenceA.IsOnline(ctx, "sara")
+		assert.NoError(t, err)
+		assert.True(t, online)
+	})
+}
+
+func presenceEvents(t *testing.T, presence *socketify.Presence) <-chan socketify.PresenceEvent {
+	events := make(chan socketify.PresenceEvent, 16)
+
+	stop, err := presence.Watch(func(event socketify.PresenceEvent) {
+		events <- event
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stop)
+
+	return events
+}
+
+func expectPresenceEvent(t *testing.T, events <-chan socketify.PresenceEvent, eventType socketify.PresenceEventType, connectionID, reason string) {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		assert.Equal(t, eventType, event.Type)
+		assert.Equal(t, connectionID, event.Entry.ConnectionID)
+		assert.Equal(t, reason, event.Reason)
+	case <-time.After(time.Second):
+		t.Fatalf("no %s event for %s", eventType, connectionID)
+	}
+}
+
+func expectNoPresenceEvent(t *testing.T, events <-chan socketify.PresenceEvent) {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected %s event for %s", event.Type, event.Entry.ConnectionID)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestPresenceRecoversExpiredEntries(t *testing.T) {
+	clock := socketifytest.NewFakeClock(time.Now())
+	backend := socketify.NewMemoryPresence()
+	ctx := context.Background()
+
+	presenceA := socketify.NewPresence(socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetNodeID("a")), backend).
+		SetTTL(time.Second * 30).
+		SetClock(clock)
+	presenceB := socketify.NewPresence(socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetNodeID("b")), backend).
+		SetTTL(time.Second * 30).
+		SetClock(clock)
+
+	events := presenceEvents(t, presenceB)
+
+	assert.NoError(t, presenceA.Track(socketifytest.NewFakeConn("1"), "ali"))
+	expectPresenceEvent(t, events, socketify.PresenceJoin, "1", "")
+
+	// a misses its heartbeats, b expires its entry although the connection is still open
+	clock.Advance(time.Second * 31)
+	presenceB.Start()
+	defer presenceB.Stop()
+	expectPresenceEvent(t, events, socketify.PresenceLeave, "1", socketify.PresenceExpired)
+
+	online, err := presenceB.IsOnline(ctx, "ali")
+	assert.NoError(t, err)
+	assert.False(t, online)
+
+	// The next heartbeat of a adds the entry back
+	presenceA.Start()
+	defer presenceA.Stop()
+	expectPresenceEvent(t, events, socketify.PresenceJoin, "1", "")
+
+	online, err = presenceB.IsOnline(ctx, "ali")
+	assert.NoError(t, err)
+	assert.True(t, online)
+}
+
+func TestPresenceTrackTwice(t *testing.T) {
+	backend := socketify.NewMemoryPresence()
+	presence := socketify.NewPresence(socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())), backend)
+
+	events := presenceEvents(t, presence)
+
+	conn := socketifytest.NewFakeConn("1")
+	assert.NoError(t, presence.Track(conn, "ali"))
+	assert.NoError(t, presence.Track(conn, "ali"))
+	expectPresenceEvent(t, events, socketify.PresenceJoin, "1", "")
+	expectNoPresenceEvent(t, events)
+
+	// Tracking for another user replaces the entry
+	assert.NoError(t, presence.Track(conn, "sara"))
+	expectPresenceEvent(t, events, socketify.PresenceLeave, "1", socketify.PresenceLeft)
+	expectPresenceEvent(t, events, socketify.PresenceJoin, "1", "")
+
+	entries, err := backend.Lookup(context.Background(), "sara")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.NoError(t, presence.Untrack("1"))
+	expectPresenceEvent(t, events, socketify.PresenceLeave, "1", socketify.PresenceLeft)
+
+	assert.NoError(t, conn.Close())
+	expectNoPresenceEvent(t, events)
+}
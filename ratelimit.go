@@ -0,0 +1,228 @@
+package socketify
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrRateLimited = errors.New("rate_limited")
+
+// RateLimit is a token bucket, Rate tokens are added every second up to Burst
+// Each update consumes one token
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type RateLimitPolicy int
+
+const (
+	// RateLimitDrop drops the update and reports ErrRateLimited on Connection.Errors()
+	RateLimitDrop RateLimitPolicy = iota
+	// RateLimitReply also sends a "rate_limited" update to the client, echoing the update's extra
+	// An error reply with the rate_limited code is sent instead when error replies are enabled
+	RateLimitReply
+	// RateLimitClose also closes the connection with code 1008 (policy violation)
+	RateLimitClose
+)
+
+const (
+	RateLimitScopeGlobal     = "global"
+	RateLimitScopeConnection = "connection"
+	RateLimitScopeUpdateType = "update_type"
+)
+
+// RateLimitState is a snapshot of a token bucket
+type RateLimitState struct {
+	Scope      string
+	UpdateType string
+	Limit      RateLimit
+	Tokens     float64
+	Allowed    uint64
+	Rejected   uint64
+}
+
+type tokenBucket struct {
+	m        sync.Mutex
+	limit    RateLimit
+	tokens   float64
+	last     time.Time
+	allowed  uint64
+	rejected uint64
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.limit.Rate
+		if burst := float64(b.limit.Burst); b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.refill(now)
+
+	if b.tokens < 1 {
+		b.rejected++
+		return false
+	}
+
+	b.tokens--
+	b.allowed++
+	return true
+}
+
+// refund gives back the token of an update that another bucket rejected afterwards
+func (b *tokenBucket) refund() {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if burst := float64(b.limit.Burst); b.tokens+1 <= burst {
+		b.tokens++
+	} else {
+		b.tokens = burst
+	}
+	b.allowed--
+}
+
+func (b *tokenBucket) state(now time.Time, scope, updateType string) RateLimitState {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.refill(now)
+
+	return RateLimitState{
+		Scope:      scope,
+		UpdateType: updateType,
+		Limit:      b.limit,
+		Tokens:     b.tokens,
+		Allowed:    b.allowed,
+		Rejected:   b.rejected,
+	}
+}
+
+// connectionRateLimiter holds the buckets of a connection, updateType is never modified after creation
+type connectionRateLimiter struct {
+	connection *tokenBucket
+	updateType map[string]*tokenBucket
+}
+
+func newConnectionRateLimiter(opts *options) *connectionRateLimiter {
+	l := &connectionRateLimiter{updateType: map[string]*tokenBucket{}}
+	now := opts.clock.Now()
+
+	if opts.connectionRateLimit != nil {
+		l.connection = newTokenBucket(*opts.connectionRateLimit, now)
+	}
+
+	for updateType, limit := range opts.updateTypeRateLimits {
+		l.updateType[updateType] = newTokenBucket(limit, now)
+	}
+
+	return l
+}
+
+// allowMessage checks the connection bucket before the global one, so a connection over its own limit doesn't spend
+// the tokens every connection shares. The connection's token is refunded when the global bucket rejects the message
+func (c *Connection) allowMessage(now time.Time) bool {
+	conn := c.rateLimiter.connection
+	if conn != nil && !conn.allow(now) {
+		return false
+	}
+
+	if global := c.server.rateLimit; global != nil && !global.allow(now) {
+		if conn != nil {
+			conn.refund()
+		}
+		return false
+	}
+
+	return true
+}
+
+// allowUpdate checks the bucket of updateType, a rejected update refunds the tokens allowMessage spent on it
+func (c *Connection) allowUpdate(now time.Time, updateType string) bool {
+	bucket := c.rateLimiter.updateType[updateType]
+	if bucket == nil || bucket.allow(now) {
+		return true
+	}
+
+	if conn := c.rateLimiter.connection; conn != nil {
+		conn.refund()
+	}
+	if global := c.server.rateLimit; global != nil {
+		global.refund()
+	}
+
+	return false
+}
+
+func (c *Connection) rateLimited(message []byte, update *Update) {
+	var extra []string
+	if update != nil {
+		extra = c.extraOf(update)
+	}
+
+	if update != nil {
+		c.logger.Warn("Rate limit exceeded", "update_type", update.Type)
+	} else {
+		c.logger.Warn("Rate limit exceeded")
+	}
+	c.reportError(ErrorCategoryRateLimit, message, update, ErrRateLimited)
+
+	switch c.server.opts.rateLimitPolicy {
+	case RateLimitReply:
+		if c.errorRepliesEnabled() {
+			c.replyError(ErrRateLimited, extra...)
+		} else if err := c.WriteUpdate("rate_limited", nil, extra...); err != nil {
+			c.logger.Error("Error writing rate limit reply", "error", err)
+		}
+	case RateLimitClose:
+		_ = c.CloseWithCode(websocket.ClosePolicyViolation, ErrRateLimited.Error())
+	}
+}
+
+// RateLimits returns the state of every rate limit applied to the connection, the global one included
+func (c *Connection) RateLimits() []RateLimitState {
+	now := c.server.opts.clock.Now()
+
+	var states []RateLimitState
+
+	if global := c.server.rateLimit; global != nil {
+		states = append(states, global.state(now, RateLimitScopeGlobal, ""))
+	}
+
+	if conn := c.rateLimiter.connection; conn != nil {
+		states = append(states, conn.state(now, RateLimitScopeConnection, ""))
+	}
+
+	updateTypes := make([]string, 0, len(c.rateLimiter.updateType))
+	for updateType := range c.rateLimiter.updateType {
+		updateTypes = append(updateTypes, updateType)
+	}
+
+	sort.Strings(updateTypes)
+
+	for _, updateType := range updateTypes {
+		states = append(states, c.rateLimiter.updateType[updateType].state(now, RateLimitScopeUpdateType, updateType))
+	}
+
+	return states
+}
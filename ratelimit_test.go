@@ -0,0 +1,255 @@
+package socketify_test
+
+import (
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func rateLimitedServer(t *testing.T, server *socketify.Server) *socketifytest.Server {
+	return socketifytest.NewPipeServer(t, server, func(c *socketify.Connection) {
+		c.HandleUpdate("ping", socketify.DataMapper[int](func(n int, extra ...string) error {
+			return c.WriteUpdate("pong", n, extra...)
+		}))
+	})
+}
+
+func expectPongs(t *testing.T, client *socketifytest.Client, want ...int) {
+	t.Helper()
+
+	for _, n := range want {
+		update := client.ExpectUpdate("pong", time.Second)
+		assert.JSONEq(t, strconv.Itoa(n), string(update.Data))
+	}
+	client.ExpectNoUpdate(time.Millisecond * 50)
+}
+
+func TestConnectionRateLimit(t *testing.T) {
+	clock := socketifytest.NewFakeClock(time.Now())
+
+	opts := socketify.ServerOptions().
+		SetLogger(socketify.NopLogger()).
+		SetClock(clock).
+		SetConnectionRateLimit(socketify.RateLimit{Rate: 1, Burst: 2})
+
+	server := rateLimitedServer(t, socketify.NewServer(opts))
+
+	first, firstConnection := server.Pair()
+	second, _ := server.Pair()
+
+	for n := 1; n <= 3; n++ {
+		first.SendUpdate("ping", n)
+	}
+	expectPongs(t, first, 1, 2)
+
+	// Each connection has its own bucket
+	second.SendUpdate("ping", 1)
+	expectPongs(t, second, 1)
+
+	clock.Advance(time.Second)
+	first.SendUpdate("ping", 4)
+	first.SendUpdate("ping", 5)
+	expectPongs(t, first, 4)
+
+	states := firstConnection.RateLimits()
+	if assert.Len(t, states, 1) {
+		assert.Equal(t, socketify.RateLimitScopeConnection, states[0].Scope)
+		assert.Equal(t, uint64(3), states[0].Allowed)
+		assert.Equal(t, uint64(2), states[0].Rejected)
+		assert.Equal(t, float64(0), states[0].Tokens)
+	}
+
+	clock.Advance(time.Millisecond * 500)
+	assert.Equal(t, 0.5, firstConnection.RateLimits()[0].Tokens)
+}
+
+func TestGlobalRateLimit(t *testing.T) {
+	clock := socketifytest.NewFakeClock(time.Now())
+
+	opts := socketify.ServerOptions().
+		SetLogger(socketify.NopLogger()).
+		SetClock(clock).
+		SetGlobalRateLimit(socketify.RateLimit{Rate: 1, Burst: 3})
+
+	server := rateLimitedServer(t, socketify.NewServer(opts))
+
+	first, firstConnection := server.Pair()
+	second, _ := server.Pair()
+
+	first.SendUpdate("ping", 1)
+	first.SendUpdate("ping", 2)
+	expectPongs(t, first, 1, 2)
+
+	// The bucket is shared by every connection
+	second.SendUpdate("ping", 1)
+	second.SendUpdate("ping", 2)
+	expectPongs(t, second, 1)
+
+	clock.Advance(time.Second)
+	first.SendUpdate("ping", 3)
+	expectPongs(t, first, 3)
+
+	states := firstConnection.RateLimits()
+	if assert.Len(t, states, 1) {
+		assert.Equal(t, socketify.RateLimitScopeGlobal, states[0].Scope)
+		assert.Equal(t, uint64(4), states[0].Allowed)
+		assert.Equal(t, uint64(1), states[0].Rejected)
+	}
+}
+
+func TestRateLimitBucketOrder(t *testing.T) {
+	t.Run("ConnectionBeforeGlobal", func(t *testing.T) {
+		opts := socketify.ServerOptions().
+			SetLogger(socketify.NopLogger()).
+			SetClock(socketifytest.NewFakeClock(time.Now())).
+			SetGlobalRateLimit(socketify.RateLimit{Rate: 1, Burst: 2}).
+			SetConnectionRateLimit(socketify.RateLimit{Rate: 1, Burst: 1})
+
+		server := rateLimitedServer(t, socketify.NewServer(opts))
+
+		first, firstConnection := server.Pair()
+		second, _ := server.Pair()
+
+		// The updates rejected by the first connection's bucket leave the global tokens to the second connection
+		for n := 1; n <= 3; n++ {
+			first.SendUpdate("ping", n)
+		}
+		expectPongs(t, first, 1)
+
+		second.SendUpdate("ping", 1)
+		expectPongs(t, second, 1)
+
+		states := firstConnection.RateLimits()
+		if assert.Len(t, states, 2) {
+			assert.Equal(t, socketify.RateLimitScopeGlobal, states[0].Scope)
+			assert.Equal(t, uint64(2), states[0].Allowed)
+			assert.Equal(t, uint64(0), states[0].Rejected)
+			assert.Equal(t, uint64(2), states[1].Rejected)
+		}
+	})
+
+	t.Run("UpdateTypeRefunds", func(t *testing.T) {
+		opts := socketify.ServerOptions().
+			SetLogger(socketify.NopLogger()).
+			SetClock(socketifytest.NewFakeClock(time.Now())).
+			SetGlobalRateLimit(socketify.RateLimit{Rate: 1, Burst: 2}).
+			SetConnectionRateLimit(socketify.RateLimit{Rate: 1, Burst: 2}).
+			SetUpdateTypeRateLimit("ping", socketify.RateLimit{Rate: 1, Burst: 1})
+
+		server := rateLimitedServer(t, socketify.NewServer(opts))
+
+		client, connection := server.Pair()
+
+		for n := 1; n <= 3; n++ {
+			client.SendUpdate("ping", n)
+		}
+		expectPongs(t, client, 1)
+
+		states := connection.RateLimits()
+		if assert.Len(t, states, 3) {
+			for _, state := range states[:2] {
+				assert.Equal(t, uint64(1), state.Allowed, state.Scope)
+				assert.Equal(t, float64(1), state.Tokens, state.Scope)
+			}
+			assert.Equal(t, uint64(2), states[2].Rejected)
+		}
+	})
+}
+
+func TestRateLimitPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    func() *socketify.Server
+		wantType  string
+		wantData  string
+		wantClose bool
+	}{
+		{
+			name: "Drop",
+			server: func() *socketify.Server {
+				return socketify.NewServer(socketify.ServerOptions().
+					SetLogger(socketify.NopLogger()).
+					SetUpdateTypeRateLimit("ping", socketify.RateLimit{Burst: 1}))
+			},
+		},
+		{
+			name: "Reply",
+			server: func() *socketify.Server {
+				return socketify.NewServer(socketify.ServerOptions().
+					SetLogger(socketify.NopLogger()).
+					SetUpdateTypeRateLimit("ping", socketify.RateLimit{Burst: 1}).
+					SetRateLimitPolicy(socketify.RateLimitReply))
+			},
+			wantType: "rate_limited",
+		},
+		{
+			name: "ErrorReply",
+			server: func() *socketify.Server {
+				return socketify.NewServer(socketify.ServerOptions().
+					SetLogger(socketify.NopLogger()).
+					SetUpdateTypeRateLimit("ping", socketify.RateLimit{Burst: 1}).
+					SetRateLimitPolicy(socketify.RateLimitReply).
+					EnableErrorReplies())
+			},
+			wantType: "error",
+			wantData: `{"code": "rate_limited", "message": "rate limit exceeded"}`,
+		},
+		{
+			name: "Close",
+			server: func() *socketify.Server {
+				return socketify.NewServer(socketify.ServerOptions().
+					SetLogger(socketify.NopLogger()).
+					SetUpdateTypeRateLimit("ping", socketify.RateLimit{Burst: 1}).
+					SetRateLimitPolicy(socketify.RateLimitClose))
+			},
+			wantClose: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := make(chan socketify.UpdateError, 1)
+
+			server := rateLimitedServer(t, tt.server())
+			got, connection := server.Pair()
+			connection.OnError(func(ue socketify.UpdateError) {
+				errs <- ue
+			})
+
+			got.SendUpdate("ping", 1, "a")
+			got.SendUpdate("ping", 2, "b")
+
+			update := got.ExpectUpdate("pong", time.Second)
+			assert.Equal(t, "a", update.Extra)
+
+			select {
+			case ue := <-errs:
+				assert.ErrorIs(t, ue.Error, socketify.ErrRateLimited)
+				assert.Equal(t, socketify.ErrorCategoryRateLimit, ue.Category)
+				assert.Equal(t, "ping", ue.UpdateType)
+			case <-time.After(time.Second):
+				t.Fatal("rate limit was not reported")
+			}
+
+			if tt.wantClose {
+				got.AssertClosedWith(websocket.ClosePolicyViolation)
+				return
+			}
+
+			if tt.wantType != "" {
+				update := got.ExpectUpdate(tt.wantType, time.Second)
+				assert.Equal(t, "b", update.Extra)
+				if tt.wantData != "" {
+					assert.JSONEq(t, tt.wantData, string(update.Data))
+				}
+			}
+
+			got.ExpectNoUpdate(time.Millisecond * 50)
+			assert.NoError(t, connection.Context().Err())
+		})
+	}
+}
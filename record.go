@@ -0,0 +1,307 @@
+package socketify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRecorderClosed       = errors.New("recorder_closed")
+	ErrConnectionIDRequired = errors.New("connection_id_required")
+)
+
+type FrameDirection string
+
+const (
+	FrameDirectionIn  FrameDirection = "in"
+	FrameDirectionOut FrameDirection = "out"
+)
+
+type RecordingSide string
+
+const (
+	RecordingSideServer RecordingSide = "server"
+	RecordingSideClient RecordingSide = "client"
+)
+
+// RecordedFrame is a line of a recording, Direction is relative to Side
+// Text frames are kept in Text, binary frames in Binary (base64 in JSON)
+type RecordedFrame struct {
+	Time         time.Time      `json:"time"`
+	Side         RecordingSide  `json:"side"`
+	Direction    FrameDirection `json:"direction"`
+	ConnectionID string         `json:"connection_id,omitempty"`
+	Text         string         `json:"text,omitempty"`
+	Binary       []byte         `json:"binary,omitempty"`
+}
+
+// FromClient reports whether the frame was sent by the client
+func (f RecordedFrame) FromClient() bool {
+	return (f.Side == RecordingSideServer) == (f.Direction == FrameDirectionIn)
+}
+
+// Data returns the payload of the frame
+func (f RecordedFrame) Data() []byte {
+	if f.Binary != nil {
+		return f.Binary
+	}
+
+	return []byte(f.Text)
+}
+
+// Recorder writes frames as JSON lines, a Recorder can be shared by several connections
+type Recorder struct {
+	m       sync.Mutex
+	closer  io.Closer
+	encoder *json.Encoder
+	err     error
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{encoder: json.NewEncoder(w)}
+}
+
+// NewFileRecorder appends frames to the file at path, creating it if needed
+// Close the recorder to flush and close the file
+func NewFileRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	bw := bufio.NewWriter(f)
+
+	r := NewRecorder(bw)
+	r.closer = closerFunc(func() error {
+		if err := bw.Flush(); err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
+	})
+
+	return r, nil
+}
+
+type closerFunc func() error
+
+func (fn closerFunc) Close() error {
+	return fn()
+}
+
+func (r *Recorder) record(side RecordingSide, direction FrameDirection, connectionID string, messageType int, data []byte) {
+	frame := RecordedFrame{
+		Time:         time.Now(),
+		Side:         side,
+		Direction:    direction,
+		ConnectionID: connectionID,
+	}
+
+	if messageType == websocket.BinaryMessage {
+		frame.Binary = append([]byte{}, data...)
+	} else {
+		frame.Text = string(data)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	r.err = r.encoder.Encode(frame)
+}
+
+// Err returns the first error writing a frame, frames aren't recorded anymore after it
+func (r *Recorder) Err() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.err
+}
+
+// Close closes the file of recorders made by NewFileRecorder, it's a no-op for others
+func (r *Recorder) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.closer == nil {
+		return nil
+	}
+
+	err := r.closer.Close()
+	r.closer = nil
+	if r.err == nil {
+		r.err = ErrRecorderClosed
+	}
+
+	return err
+}
+
+// SetRecorder records every frame read from and written to the connection, nil stops recording
+func (c *Connection) SetRecorder(r *Recorder) {
+	c.writer.recorder.Store(r)
+}
+
+// SetRecorder records every frame read from and written to the client, nil stops recording
+func (c *Client) SetRecorder(r *Recorder) *Client {
+	c.writer.recorder.Store(r)
+
+	return c
+}
+
+// recordFrame records a frame if a recorder is set
+func (w *writer) recordFrame(direction FrameDirection, messageType int, data []byte) {
+	r := w.recorder.Load()
+	if r == nil {
+		return
+	}
+
+	if w.connection != nil {
+		r.record(RecordingSideServer, direction, w.connection.id, messageType, data)
+		return
+	}
+
+	r.record(RecordingSideClient, direction, "", messageType, data)
+}
+
+// ReadRecording reads the frames of a recording
+func ReadRecording(r io.Reader) ([]RecordedFrame, error) {
+	var frames []RecordedFrame
+
+	decoder := json.NewDecoder(r)
+	for {
+		var frame RecordedFrame
+		if err := decoder.Decode(&frame); err == io.EOF {
+			return frames, nil
+		} else if err != nil {
+			return frames, err
+		}
+
+		frames = append(frames, frame)
+	}
+}
+
+// LoadRecording reads the frames of the recording file at path
+func LoadRecording(path string) ([]RecordedFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadRecording(f)
+}
+
+// Replayer plays recorded frames back, by default at their original pace
+type Replayer struct {
+	frames       []RecordedFrame
+	speed        float64
+	connectionID string
+}
+
+func NewReplayer(frames []RecordedFrame) *Replayer {
+	return &Replayer{frames: frames, speed: 1}
+}
+
+// SetSpeed scales the delays between frames, 2 replays twice as fast and 0 replays without any delay
+func (r *Replayer) SetSpeed(speed float64) *Replayer {
+	r.speed = speed
+	return r
+}
+
+// SetConnectionID only replays the frames of a connection, for recordings shared by several connections
+// It's required when the frames to replay belong to more than one connection, replays return ErrConnectionIDRequired otherwise
+func (r *Replayer) SetConnectionID(connectionID string) *Replayer {
+	r.connectionID = connectionID
+	return r
+}
+
+// ReplayConnection feeds the frames sent by the client to the connection's middlewares and handlers
+// as if they were read from its socket, it returns when every frame was handed over or ctx is done
+// The rate and size limits don't apply, so a replay at a higher speed doesn't drop frames
+func (r *Replayer) ReplayConnection(ctx context.Context, c *Connection) error {
+	return r.replay(ctx, true, func(frame RecordedFrame) error {
+		c.handleMessage(frame.Data(), true)
+		return nil
+	})
+}
+
+// ReplayClient feeds the frames sent by the server to the client's handlers as if they were read from its socket
+func (r *Replayer) ReplayClient(ctx context.Context, cl *Client) error {
+	return r.replay(ctx, false, func(frame RecordedFrame) error {
+		cl.handleMessage(frame.Data())
+		return nil
+	})
+}
+
+// SendWithClient sends the frames sent by the client again, through cl to the server it's connected to
+func (r *Replayer) SendWithClient(ctx context.Context, cl *Client) error {
+	return r.replay(ctx, true, func(frame RecordedFrame) error {
+		if frame.Binary != nil {
+			return cl.WriteBinaryBytes(frame.Binary)
+		}
+		return cl.WriteText(frame.Text)
+	})
+}
+
+func (r *Replayer) replay(ctx context.Context, fromClient bool, fn func(frame RecordedFrame) error) error {
+	if r.connectionID == "" && r.connections(fromClient) > 1 {
+		return ErrConnectionIDRequired
+	}
+
+	var previous time.Time
+
+	for _, frame := range r.frames {
+		if frame.FromClient() != fromClient {
+			continue
+		}
+
+		if r.connectionID != "" && frame.ConnectionID != r.connectionID {
+			continue
+		}
+
+		if r.speed > 0 && !previous.IsZero() {
+			if delay := time.Duration(float64(frame.Time.Sub(previous)) / r.speed); delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		previous = frame.Time
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(frame); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// connections counts the connections the frames sent in a direction belong to
+func (r *Replayer) connections(fromClient bool) int {
+	ids := map[string]struct{}{}
+	for _, frame := range r.frames {
+		if frame.FromClient() == fromClient {
+			ids[frame.ConnectionID] = struct{}{}
+		}
+	}
+
+	return len(ids)
+}
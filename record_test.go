@@ -0,0 +1,214 @@
+package socketify_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lockedBuffer struct {
+	m sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return append([]byte(nil), b.b.Bytes()...)
+}
+
+func TestRecordAndReplayClient(t *testing.T) {
+	address := runWritingServer(t, []string{
+		`{"type": "count", "data": 1}`,
+		`{"type": "count", "data": 2}`,
+	})
+
+	var buf lockedBuffer
+
+	client, err := socketify.NewClientWithOptions(address, socketify.ClientOptions().SetLogger(socketify.NopLogger()).SetDispatchMode(socketify.DispatchSequential()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close(1000, "")
+
+	client.SetRecorder(socketify.NewRecorder(&buf))
+
+	counts := make(chan string, 4)
+	client.SetUpdateTypeHandler("count", func(message json.RawMessage) {
+		counts <- string(message)
+	})
+
+	assert.NoError(t, client.WriteText(`{"type": "start"}`))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-counts:
+		case <-time.After(time.Second):
+			t.Fatal("update not received")
+		}
+	}
+
+	frames, err := socketify.ReadRecording(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+
+	if assert.Len(t, frames, 3) {
+		assert.Equal(t, socketify.FrameDirectionOut, frames[0].Direction)
+		assert.True(t, frames[0].FromClient())
+		assert.Equal(t, `{"type": "start"}`, frames[0].Text)
+		assert.Equal(t, socketify.FrameDirectionIn, frames[1].Direction)
+		assert.False(t, frames[1].FromClient())
+	}
+
+	client.SetRecorder(nil)
+
+	err = socketify.NewReplayer(frames).SetSpeed(0).ReplayClient(context.Background(), client)
+	assert.NoError(t, err)
+
+	for _, want := range []string{"1", "2"} {
+		select {
+		case got := <-counts:
+			assert.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatal("replayed update not received")
+		}
+	}
+}
+
+func TestRecordAndReplayConnection(t *testing.T) {
+	var (
+		m    sync.Mutex
+		seen = map[string][]string{}
+	)
+
+	handle := func(c *socketify.Connection) {
+		c.HandleUpdate("step", socketify.DataMapper[string](func(step string, extra ...string) error {
+			m.Lock()
+			defer m.Unlock()
+
+			seen[c.ID()] = append(seen[c.ID()], step+extra[0])
+			return nil
+		}))
+	}
+
+	stepsOf := func(c *socketify.Connection) []string {
+		m.Lock()
+		defer m.Unlock()
+
+		return append([]string(nil), seen[c.ID()]...)
+	}
+
+	opts := socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetDispatchMode(socketify.DispatchSequential())
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(opts), nil)
+
+	var buf lockedBuffer
+
+	got, recorded := server.Pair()
+	handle(recorded)
+	recorded.SetRecorder(socketify.NewRecorder(&buf))
+
+	want := []string{"a1", "b2", "c3"}
+	got.SendUpdate("step", "a", "1")
+	got.SendUpdate("step", "b", "2")
+	got.SendUpdate("step", "c", "3")
+
+	assert.Eventually(t, func() bool {
+		return len(stepsOf(recorded)) == len(want)
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, want, stepsOf(recorded))
+
+	frames, err := socketify.ReadRecording(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+
+	// Frames of other connections sharing the recorder are skipped
+	frames = append(frames, socketify.RecordedFrame{
+		Side:         socketify.RecordingSideServer,
+		Direction:    socketify.FrameDirectionIn,
+		ConnectionID: "other",
+		Text:         `{"type": "step", "data": "x", "extra": "0"}`,
+	})
+
+	_, replayed := server.Pair()
+	handle(replayed)
+
+	err = socketify.NewReplayer(frames).
+		SetSpeed(0).
+		SetConnectionID(recorded.ID()).
+		ReplayConnection(context.Background(), replayed)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(stepsOf(replayed)) == len(want)
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, want, stepsOf(replayed))
+}
+
+func TestReplayConnectionLimits(t *testing.T) {
+	opts := socketify.ServerOptions().
+		SetLogger(socketify.NopLogger()).
+		SetDispatchMode(socketify.DispatchSequential()).
+		SetClock(socketifytest.NewFakeClock(time.Now())).
+		SetConnectionRateLimit(socketify.RateLimit{Rate: 1, Burst: 1}).
+		SetMaxUpdateDataSize("step", 3)
+
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(opts), nil)
+
+	_, connection := server.Pair()
+
+	steps := make(chan string, 3)
+	connection.HandleUpdate("step", socketify.DataMapper[string](func(step string, _ ...string) error {
+		steps <- step
+		return nil
+	}))
+
+	// Three updates at once are over the rate limit, and the last one is over the size limit
+	var frames []socketify.RecordedFrame
+	for _, step := range []string{"a", "b", "long"} {
+		frames = append(frames, socketify.RecordedFrame{
+			Side:      socketify.RecordingSideServer,
+			Direction: socketify.FrameDirectionIn,
+			Text:      `{"type": "step", "data": "` + step + `"}`,
+		})
+	}
+
+	assert.NoError(t, socketify.NewReplayer(frames).SetSpeed(0).ReplayConnection(context.Background(), connection))
+
+	for _, want := range []string{"a", "b", "long"} {
+		select {
+		case step := <-steps:
+			assert.Equal(t, want, step)
+		case <-time.After(time.Second):
+			t.Fatalf("replayed step %s not handled", want)
+		}
+	}
+}
+
+func TestReplayConnectionIDRequired(t *testing.T) {
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())), nil)
+
+	_, connection := server.Pair()
+
+	frames := []socketify.RecordedFrame{
+		{Side: socketify.RecordingSideServer, Direction: socketify.FrameDirectionIn, ConnectionID: "a", Text: `{"type": "step"}`},
+		{Side: socketify.RecordingSideServer, Direction: socketify.FrameDirectionIn, ConnectionID: "b", Text: `{"type": "step"}`},
+	}
+
+	err := socketify.NewReplayer(frames).SetSpeed(0).ReplayConnection(context.Background(), connection)
+	assert.ErrorIs(t, err, socketify.ErrConnectionIDRequired)
+
+	err = socketify.NewReplayer(frames).SetSpeed(0).SetConnectionID("a").ReplayConnection(context.Background(), connection)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,149 @@
+package socketify
+
+import (
+	"context"
+	"sync"
+)
+
+// Join adds c to room, it requires EnableStorage() and fails if c is closed
+func (c *Connection) Join(room string) error {
+	if c.server.storage == nil {
+		return ErrStorageDisabled
+	}
+
+	return c.server.storage.join(room, c)
+}
+
+// Leave removes c from room, it reports whether c was in it
+func (c *Connection) Leave(room string) bool {
+	if c.server.storage == nil {
+		return false
+	}
+
+	return c.server.storage.leave(room, c)
+}
+
+// Rooms returns the rooms c is in
+func (c *Connection) Rooms() []string {
+	if c.server.storage == nil {
+		return nil
+	}
+
+	return c.server.storage.roomsOfConnection(c)
+}
+
+// RoomMembers returns the connections of this instance in room
+func (s *Server) RoomMembers(room string) []*Connection {
+	if s.storage == nil {
+		return nil
+	}
+
+	return s.storage.roomMembers(room)
+}
+
+// BroadcastToRoom writes an update to every connection in room, on every instance sharing the broker
+// It needs storage, the local connections are written to before BroadcastToRoom returns
+func (s *Server) BroadcastToRoom(room, updateType string, data interface{}, extra ...string) error {
+	return s.BroadcastToRoomContext(context.Background(), room, updateType, data, extra...)
+}
+
+// BroadcastToRoomContext is like BroadcastToRoom but the updates continue the trace of the span in ctx
+func (s *Server) BroadcastToRoomContext(ctx context.Context, room, updateType string, data interface{}, extra ...string) error {
+	if s.storage == nil {
+		return ErrStorageDisabled
+	}
+
+	s.broadcastToRoomLocal(ctx, room, updateType, data, extra...)
+
+	if s.opts.broker == nil {
+		return nil
+	}
+
+	message, err := s.newRoomBrokerMessage(ctx, room, updateType, data, extra...)
+	if err != nil {
+		return err
+	}
+
+	return s.opts.broker.Publish(ctx, roomChannel(), message)
+}
+
+func (s *Server) broadcastToRoomLocal(ctx context.Context, room, updateType string, data interface{}, extra ...string) {
+	var wg sync.WaitGroup
+	for _, c := range s.storage.roomMembers(room) {
+		wg.Add(1)
+		go func(c *Connection) {
+			defer wg.Done()
+
+			if err := c.WriteUpdateContext(ctx, updateType, data, extra...); err != nil {
+				c.logger.Warn("Error broadcasting update to room", "room", room, "update_type", updateType, "error", err)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// join adds c to room, it fails if c was already removed from the storage
+func (s *storage) join(room string, c *Connection) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.clients[c.id] != c {
+		return ErrConnectionClosed
+	}
+
+	members := s.rooms[room]
+	if members == nil {
+		members = map[string]*Connection{}
+		s.rooms[room] = members
+	}
+	members[c.id] = c
+	addMembership(s.roomsOf, c, room)
+
+	return nil
+}
+
+func (s *storage) leave(room string, c *Connection) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.rooms[room][c.id] != c {
+		return false
+	}
+
+	s.removeMember(room, c)
+	removeMembership(s.roomsOf, c, room)
+
+	return true
+}
+
+// removeMember removes c from room, the caller holds s.m
+func (s *storage) removeMember(room string, c *Connection) {
+	members := s.rooms[room]
+	if members[c.id] != c {
+		return
+	}
+
+	delete(members, c.id)
+	if len(members) == 0 {
+		delete(s.rooms, room)
+	}
+}
+
+func (s *storage) roomMembers(room string) []*Connection {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	members := make([]*Connection, 0, len(s.rooms[room]))
+	for _, c := range s.rooms[room] {
+		members = append(members, c)
+	}
+
+	return members
+}
+
+func (s *storage) roomsOfConnection(c *Connection) []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return sortedMemberships(s.roomsOf, c)
+}
@@ -0,0 +1,74 @@
+package socketify_test
+
+import (
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRooms(t *testing.T) {
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).EnableStorage()), nil)
+
+	first, firstConnection := server.Pair()
+	second, secondConnection := server.Pair()
+
+	assert.NoError(t, firstConnection.Join("lobby"))
+	assert.NoError(t, firstConnection.Join("game"))
+	assert.NoError(t, secondConnection.Join("lobby"))
+
+	assert.Equal(t, []string{"game", "lobby"}, firstConnection.Rooms())
+	want := []string{firstConnection.ID(), secondConnection.ID()}
+	sort.Strings(want)
+	assert.Equal(t, want, ids(server.RoomMembers("lobby")))
+
+	assert.NoError(t, server.BroadcastToRoom("game", "started", 1, "x"))
+	update := first.ExpectUpdate("started", time.Second)
+	assert.Equal(t, "x", update.Extra)
+	second.ExpectNoUpdate(time.Millisecond * 50)
+
+	assert.True(t, firstConnection.Leave("game"))
+	assert.False(t, firstConnection.Leave("game"))
+	assert.Equal(t, []string{"lobby"}, firstConnection.Rooms())
+	assert.Empty(t, server.RoomMembers("game"))
+
+	second.Close(websocket.CloseNormalClosure, "")
+	socketifytest.AssertConnectionClosedWith(t, secondConnection, websocket.CloseNormalClosure)
+
+	assert.Eventually(t, func() bool {
+		return len(server.RoomMembers("lobby")) == 1
+	}, time.Second, time.Millisecond*10)
+	assert.ErrorIs(t, secondConnection.Join("lobby"), socketify.ErrConnectionClosed)
+}
+
+func TestRoomsStorageDisabled(t *testing.T) {
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger())), nil)
+
+	_, connection := server.Pair()
+
+	assert.ErrorIs(t, connection.Join("lobby"), socketify.ErrStorageDisabled)
+	assert.False(t, connection.Leave("lobby"))
+	assert.Nil(t, connection.Rooms())
+	assert.ErrorIs(t, server.BroadcastToRoom("lobby", "started", 1), socketify.ErrStorageDisabled)
+}
+
+func TestRoomsStaleCloseOfReusedID(t *testing.T) {
+	server := socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).EnableStorage())
+
+	first, second := sameIDConnections(t, server)
+
+	assert.NoError(t, second.Join("lobby"))
+	assert.ErrorIs(t, first.Join("lobby"), socketify.ErrConnectionClosed)
+	assert.False(t, first.Leave("lobby"))
+	assert.NoError(t, first.Close())
+
+	assert.Equal(t, []*socketify.Connection{second}, server.RoomMembers("lobby"))
+	assert.Equal(t, []string{"lobby"}, second.Rooms())
+
+	assert.NoError(t, second.Close())
+	assert.Empty(t, server.RoomMembers("lobby"))
+	assert.Nil(t, second.Rooms())
+}
@@ -2,6 +2,7 @@ package socketify
 
 import (
 	"github.com/gorilla/websocket"
+	"net"
 	"net/http"
 )
 
@@ -11,6 +12,11 @@ type Server struct {
 	server          *http.Server
 	upgradeRequests chan *UpgradeRequest
 	storage         *storage
+	rateLimit       *tokenBucket
+	connLimiter     *connLimiter
+	trustedProxies  []*net.IPNet
+	metrics         *metrics
+	topics          *topicRegistry
 }
 
 func NewServer(opts *options) (s *Server) {
@@ -20,17 +26,16 @@ func NewServer(opts *options) (s *Server) {
 
 	opts.fillDefaults()
 
-	var upgrade = &websocket.Upgrader{CheckOrigin: func(r *http.Request) bool {
-		return true
-	}}
-
-	if opts.checkOrigin != nil {
-		upgrade.CheckOrigin = opts.checkOrigin
+	var upgrade = &websocket.Upgrader{
+		ReadBufferSize:  opts.readBufferSize,
+		WriteBufferSize: opts.writeBufferSize,
+		WriteBufferPool: opts.writeBufferPool,
+		CheckOrigin:     opts.originChecker(),
 	}
 
 	var storage *storage
 	if opts.enableStorage {
-		storage = newStorage()
+		storage = newStorage(opts.indexedAttributes)
 	}
 
 	s = &Server{
@@ -39,17 +44,49 @@ func NewServer(opts *options) (s *Server) {
 		upgrade:         upgrade,
 		upgradeRequests: make(chan *UpgradeRequest),
 		storage:         storage,
+		connLimiter:     newConnLimiter(),
+		trustedProxies:  opts.trustedProxies,
+	}
+
+	if opts.trustedProxiesErr != nil {
+		opts.logger.Error("Ignoring invalid trusted proxies", "error", opts.trustedProxiesErr)
+	}
+
+	if opts.enableMetrics {
+		s.metrics = newMetrics()
+	}
+
+	if opts.enableTopics {
+		s.topics = newTopicRegistry()
+	}
+
+	if opts.broker != nil {
+		s.subscribeBroker()
+	}
+
+	if opts.globalRateLimit != nil {
+		s.rateLimit = newTokenBucket(*opts.globalRateLimit, opts.clock.Now())
 	}
 
 	return
 }
 
 func (s *Server) Listen() (err error) {
+	if err = s.opts.trustedProxiesErr; err != nil {
+		return
+	}
+
 	s.opts.serveMux.HandleFunc(s.opts.endpoint, s.websocketUpgrade)
 	err = s.server.ListenAndServe()
 	return
 }
 
+// ServeHTTP upgrades requests like the endpoint served by Listen, use it to serve the server from your own http.Server
+// Upgrade requests are received on UpgradeRequests()
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.websocketUpgrade(w, r)
+}
+
 func (s *Server) Server() (server *http.Server) {
 	return s.server
 }
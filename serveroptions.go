@@ -2,6 +2,10 @@ package socketify
 
 import (
 	"crypto/rsa"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/teris-io/shortid"
+	"net"
 	"net/http"
 )
 
@@ -11,13 +15,51 @@ const (
 )
 
 type options struct {
-	serveMux      *http.ServeMux
-	address       string
-	endpoint      string
-	checkOrigin   func(r *http.Request) bool
-	logger        Logger
-	enableStorage bool
-	encryption    *encryption
+	serveMux              *http.ServeMux
+	address               string
+	endpoint              string
+	checkOrigin           func(r *http.Request) bool
+	logger                Logger
+	enableStorage         bool
+	enableMetrics         bool
+	enableTopics          bool
+	broker                Broker
+	nodeID                string
+	spanExporter          SpanExporter
+	encryption            *encryption
+	middlewares           []Middleware
+	updateTypeMiddlewares map[string][]Middleware
+	outgoingInterceptors  []OutgoingInterceptor
+	disablePanicRecovery  bool
+	panicReply            *panicReply
+	dispatchMode          DispatchMode
+	globalRateLimit       *RateLimit
+	connectionRateLimit   *RateLimit
+	updateTypeRateLimits  map[string]RateLimit
+	rateLimitPolicy       RateLimitPolicy
+	clock                 Clock
+	maxMessageSize        int64
+	readBufferSize        int
+	writeBufferSize       int
+	writeBufferPool       websocket.BufferPool
+	maxUpdateDataSizes    map[string]int
+	maxConnections        int
+	maxConnectionsPerIP   int
+	trustedProxies        []*net.IPNet
+	trustedProxiesErr     error
+	allowedOrigins        []string
+	errorReplyType        string
+	errorBufferSize       int
+	errorOverflowPolicy   ErrorOverflowPolicy
+	brokerQueueSize       int
+
+	maxConnectionsPerAttribute map[string]int
+	indexedAttributes          []string
+}
+
+type panicReply struct {
+	updateType string
+	data       interface{}
 }
 
 func defaultOptions() *options {
@@ -25,7 +67,7 @@ func defaultOptions() *options {
 		serveMux: http.NewServeMux(),
 		address:  defaultAddress,
 		endpoint: defaultEndpoint,
-		logger:   logger{},
+		logger:   defaultLogger(),
 	}
 }
 
@@ -48,6 +90,8 @@ func (o *options) SetAddress(address string) *options {
 	return o
 }
 
+// SetCheckOrigin replaces the origin check, it takes precedence over SetAllowedOrigins
+// By default only same-origin requests (and requests without an Origin header) are accepted
 func (o *options) SetCheckOrigin(checkOriginFn func(r *http.Request) bool) *options {
 	o.checkOrigin = checkOriginFn
 	return o
@@ -58,6 +102,15 @@ func (o *options) SetLogger(l Logger) *options {
 	return o
 }
 
+// SetAllowedOrigins accepts requests whose Origin matches one of the patterns: [scheme://]host[:port]
+// Each part can be "*", host can start with "*." to match subdomains and a missing port only matches the scheme's default port
+// e.g. "https://*.example.com", "http://localhost:*", "example.org"
+func (o *options) SetAllowedOrigins(patterns ...string) *options {
+	o.allowedOrigins = append(o.allowedOrigins, patterns...)
+	return o
+}
+
+// IgnoreCheckOrigin accepts requests from any origin, it leaves your users open to cross-site WebSocket hijacking
 func (o *options) IgnoreCheckOrigin() *options {
 	o.checkOrigin = func(r *http.Request) bool {
 		return true
@@ -65,11 +118,236 @@ func (o *options) IgnoreCheckOrigin() *options {
 	return o
 }
 
+// SetSpanExporter enables tracing, a span is started for every incoming update and every WriteUpdate
+// Spans continue the trace of the update's "traceparent" field, handlers get the span in their context (see SpanFromContext)
+func (o *options) SetSpanExporter(exporter SpanExporter) *options {
+	o.spanExporter = exporter
+	return o
+}
+
+// EnableMetrics collects connection, message, byte, latency and close code metrics
+// They're served by server.MetricsHandler() in the Prometheus text format
+func (o *options) EnableMetrics() *options {
+	o.enableMetrics = true
+	return o
+}
+
 func (o *options) EnableStorage() *options {
 	o.enableStorage = true
 	return o
 }
 
+// EnableTopics lets clients subscribe to the topics created with NewTopic, it enables storage
+// Connections get built-in handlers for subscribe and unsubscribe updates
+func (o *options) EnableTopics() *options {
+	o.enableTopics = true
+	o.enableStorage = true
+	return o
+}
+
+// SetBroker shares broadcasts, topic events and SendToClient with the other instances using the same broker, it enables storage
+// Each upgrade waits for the broker to subscribe the connection to its updates, closing unsubscribes in the background
+func (o *options) SetBroker(broker Broker) *options {
+	o.broker = broker
+	o.enableStorage = true
+	return o
+}
+
+// SetBrokerQueueSize sets how many updates received from the broker may wait to be written to a connection
+// A connection falling further behind is closed with 1013 Try Again Later, the default is 1024
+func (o *options) SetBrokerQueueSize(size int) *options {
+	o.brokerQueueSize = size
+	return o
+}
+
+// SetNodeID sets the ID of this instance, it defaults to a random ID
+func (o *options) SetNodeID(nodeID string) *options {
+	o.nodeID = nodeID
+	return o
+}
+
+// IndexAttributes keeps an index of the values of attribute keys, so GetClientsByAttributeValue doesn't scan
+// every connection for them, it enables storage. Values that can't be compared (slices, maps...) aren't indexed
+func (o *options) IndexAttributes(keys ...string) *options {
+	o.indexedAttributes = append(o.indexedAttributes, keys...)
+	o.enableStorage = true
+	return o
+}
+
+// Use appends middlewares that run for every update on every connection, before connection level middlewares
+func (o *options) Use(middlewares ...Middleware) *options {
+	o.middlewares = append(o.middlewares, middlewares...)
+	return o
+}
+
+// UseForUpdate appends middlewares that run for updateType on every connection
+func (o *options) UseForUpdate(updateType string, middlewares ...Middleware) *options {
+	if o.updateTypeMiddlewares == nil {
+		o.updateTypeMiddlewares = map[string][]Middleware{}
+	}
+	o.updateTypeMiddlewares[updateType] = append(o.updateTypeMiddlewares[updateType], middlewares...)
+	return o
+}
+
+// AddOutgoingInterceptor appends interceptors that run for every outgoing message of every connection
+// They run before the ones added with Connection.AddOutgoingInterceptor
+func (o *options) AddOutgoingInterceptor(interceptors ...OutgoingInterceptor) *options {
+	o.outgoingInterceptors = append(o.outgoingInterceptors, interceptors...)
+	return o
+}
+
+// DisablePanicRecovery lets panics in handlers and middlewares crash the process
+// By default they're recovered and reported as a *PanicError on Connection.Errors()
+func (o *options) DisablePanicRecovery() *options {
+	o.disablePanicRecovery = true
+	return o
+}
+
+// SetPanicReply sends an update with updateType and data to the client when its update caused a panic
+// The update's extra is echoed back. Don't put panic details in data, they're only reported on the server side
+func (o *options) SetPanicReply(updateType string, data interface{}) *options {
+	o.panicReply = &panicReply{updateType: updateType, data: data}
+	return o
+}
+
+// SetDispatchMode sets how the handlers of each connection are run, the default is DispatchSequential()
+func (o *options) SetDispatchMode(mode DispatchMode) *options {
+	o.dispatchMode = mode
+	return o
+}
+
+// SetGlobalRateLimit limits the updates received by the server from all connections together
+func (o *options) SetGlobalRateLimit(limit RateLimit) *options {
+	o.globalRateLimit = &limit
+	return o
+}
+
+// SetConnectionRateLimit limits the updates received from each connection
+func (o *options) SetConnectionRateLimit(limit RateLimit) *options {
+	o.connectionRateLimit = &limit
+	return o
+}
+
+// SetUpdateTypeRateLimit limits the updates of updateType received from each connection
+func (o *options) SetUpdateTypeRateLimit(updateType string, limit RateLimit) *options {
+	if o.updateTypeRateLimits == nil {
+		o.updateTypeRateLimits = map[string]RateLimit{}
+	}
+	o.updateTypeRateLimits[updateType] = limit
+	return o
+}
+
+// SetRateLimitPolicy sets what happens to updates exceeding a rate limit, the default is RateLimitDrop
+func (o *options) SetRateLimitPolicy(policy RateLimitPolicy) *options {
+	o.rateLimitPolicy = policy
+	return o
+}
+
+// SetClock sets the time source of rate limits, it's meant for tests
+func (o *options) SetClock(clock Clock) *options {
+	o.clock = clock
+	return o
+}
+
+// SetMaxMessageSize sets the maximum size in bytes of a message read from a connection
+// The connection is closed with code 1009 (message too big) when a client exceeds it
+func (o *options) SetMaxMessageSize(size int64) *options {
+	o.maxMessageSize = size
+	return o
+}
+
+// SetReadBufferSize sets the I/O read buffer size in bytes of each connection
+func (o *options) SetReadBufferSize(size int) *options {
+	o.readBufferSize = size
+	return o
+}
+
+// SetWriteBufferSize sets the I/O write buffer size in bytes of each connection
+func (o *options) SetWriteBufferSize(size int) *options {
+	o.writeBufferSize = size
+	return o
+}
+
+// SetWriteBufferPool shares write buffers between connections, a *sync.Pool can be passed
+// It saves memory when there are many connections writing occasionally
+func (o *options) SetWriteBufferPool(pool websocket.BufferPool) *options {
+	o.writeBufferPool = pool
+	return o
+}
+
+// SetMaxUpdateDataSize sets the maximum size in bytes of the data of updateType
+// Larger updates are dropped and reported as ErrUpdateTooLarge before reaching handlers
+func (o *options) SetMaxUpdateDataSize(updateType string, size int) *options {
+	if o.maxUpdateDataSizes == nil {
+		o.maxUpdateDataSizes = map[string]int{}
+	}
+	o.maxUpdateDataSizes[updateType] = size
+	return o
+}
+
+// SetMaxConnections limits the number of open connections, further upgrades are rejected with 503 Service Unavailable
+func (o *options) SetMaxConnections(max int) *options {
+	o.maxConnections = max
+	return o
+}
+
+// SetMaxConnectionsPerIP limits the number of open connections per remote IP, further upgrades are rejected with 429 Too Many Requests
+// See SetTrustedProxies when running behind a reverse proxy
+func (o *options) SetMaxConnectionsPerIP(max int) *options {
+	o.maxConnectionsPerIP = max
+	return o
+}
+
+// SetMaxConnectionsPerAttribute limits the number of open connections sharing the same value of an attribute
+// set with UpgradeRequest.SetAttribute before Upgrade (e.g. a user ID), further upgrades are rejected with 429 Too Many Requests
+func (o *options) SetMaxConnectionsPerAttribute(key string, max int) *options {
+	if o.maxConnectionsPerAttribute == nil {
+		o.maxConnectionsPerAttribute = map[string]int{}
+	}
+	o.maxConnectionsPerAttribute[key] = max
+	return o
+}
+
+// SetTrustedProxies sets the IPs or CIDRs of the proxies allowed to set X-Forwarded-For
+// Invalid values are ignored, so X-Forwarded-For isn't trusted from them, and Listen returns ErrInvalidTrustedProxy
+func (o *options) SetTrustedProxies(proxies ...string) *options {
+	for _, proxy := range proxies {
+		ipNet, err := parseTrustedProxy(proxy)
+		if err != nil {
+			o.trustedProxiesErr = errors.Join(o.trustedProxiesErr, err)
+			continue
+		}
+
+		o.trustedProxies = append(o.trustedProxies, ipNet)
+	}
+	return o
+}
+
+// EnableErrorReplies sends a structured error update to the client whenever one of its updates fails:
+// {"type": "error", "data": {"code": "", "message": "", "details": ...}, "extra": "<echoed extra>"}
+// Handlers can return a *socketify.Error to control the code, message and details, other errors are sent as internal_error
+func (o *options) EnableErrorReplies() *options {
+	if o.errorReplyType == "" {
+		o.errorReplyType = defaultErrorReplyType
+	}
+	return o
+}
+
+// SetErrorReplyType enables error replies using updateType instead of "error"
+func (o *options) SetErrorReplyType(updateType string) *options {
+	o.errorReplyType = updateType
+	return o
+}
+
+// SetErrorBuffer sets the buffer size of Connection.Errors() and what happens when it's full
+// The default is lossy: a buffer of 64 errors with ErrorOverflowDropNewest, errors are dropped when nobody reads them
+// and counted by Connection.DroppedErrors. Connection.OnError sees every error whatever the policy
+func (o *options) SetErrorBuffer(size int, policy ErrorOverflowPolicy) *options {
+	o.errorBufferSize = size
+	o.errorOverflowPolicy = policy
+	return o
+}
+
 func (o *options) EnableRsaAesEncryption(publicKeyPemFn func() (*rsa.PrivateKey, error)) *options {
 	o.encryption = &encryption{
 		Method: EncryptionTypeRsaAes,
@@ -90,6 +368,18 @@ func (o *options) fillDefaults() {
 		o.serveMux = http.NewServeMux()
 	}
 	if o.logger == nil {
-		o.logger = logger{}
+		o.logger = defaultLogger()
+	}
+	if o.errorBufferSize <= 0 {
+		o.errorBufferSize = defaultErrorBufferSize
+	}
+	if o.brokerQueueSize <= 0 {
+		o.brokerQueueSize = defaultBrokerQueueSize
+	}
+	if o.nodeID == "" {
+		o.nodeID = shortid.MustGenerate()
+	}
+	if o.clock == nil {
+		o.clock = realClock{}
 	}
 }
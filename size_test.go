@@ -0,0 +1,81 @@
+package socketify_test
+
+import (
+	"context"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxMessageSize(t *testing.T) {
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetMaxMessageSize(1024)), nil)
+
+	got, connection := server.Pair()
+
+	assert.NoError(t, got.WriteText(strings.Repeat("a", 2048)))
+
+	got.AssertClosedWith(websocket.CloseMessageTooBig)
+
+	select {
+	case <-connection.Context().Done():
+		assert.ErrorIs(t, context.Cause(connection.Context()), websocket.ErrReadLimit)
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed")
+	}
+}
+
+func TestMaxUpdateDataSize(t *testing.T) {
+	errs := make(chan socketify.UpdateError, 1)
+
+	opts := socketify.ServerOptions().
+		SetLogger(socketify.NopLogger()).
+		SetMaxUpdateDataSize("upload", 16).
+		EnableErrorReplies()
+
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(opts), func(c *socketify.Connection) {
+		c.OnError(func(ue socketify.UpdateError) {
+			errs <- ue
+		})
+
+		for _, updateType := range []string{"upload", "ping"} {
+			updateType := updateType
+			c.HandleUpdate(updateType, socketify.DataMapper[string](func(data string, extra ...string) error {
+				return c.WriteUpdate(updateType+"_ok", len(data), extra...)
+			}))
+		}
+	})
+
+	got, connection := server.Pair()
+
+	big := strings.Repeat("a", 32)
+
+	got.SendUpdate("upload", big, "1")
+
+	update := got.ExpectUpdate("error", time.Second)
+	assert.JSONEq(t, `{"code": "update_too_large", "message": "update data is too large"}`, string(update.Data))
+	assert.Equal(t, "1", update.Extra)
+
+	select {
+	case ue := <-errs:
+		assert.ErrorIs(t, ue.Error, socketify.ErrUpdateTooLarge)
+		assert.Equal(t, socketify.ErrorCategorySizeLimit, ue.Category)
+		assert.Equal(t, "upload", ue.UpdateType)
+	case <-time.After(time.Second):
+		t.Fatal("size limit was not reported")
+	}
+
+	// Only the limited update type is rejected
+	got.SendUpdate("ping", big)
+	update = got.ExpectUpdate("ping_ok", time.Second)
+	assert.JSONEq(t, `32`, string(update.Data))
+
+	got.SendUpdate("upload", "small")
+	update = got.ExpectUpdate("upload_ok", time.Second)
+	assert.JSONEq(t, `5`, string(update.Data))
+
+	assert.NoError(t, connection.Context().Err())
+}
@@ -0,0 +1,135 @@
+package socketifytest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aliforever/go-socketify"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+// Client is a socketify client whose received updates are queued for ExpectUpdate
+// Its raw handler and onClose callback are used by the harness and must not be replaced
+type Client struct {
+	*socketify.Client
+
+	t       testing.TB
+	updates chan []byte
+	closed  chan error
+}
+
+func newClient(t testing.TB, client *socketify.Client) *Client {
+	c := &Client{
+		Client:  client,
+		t:       t,
+		updates: make(chan []byte, 256),
+		closed:  make(chan error, 1),
+	}
+
+	client.SetRawHandler(func(message []byte) {
+		c.updates <- message
+	})
+	client.SetOnClose(func(err error) {
+		c.closed <- err
+	})
+
+	return c
+}
+
+// ExpectUpdate waits for an update of updateType and returns it, updates of other types received meanwhile are skipped
+// It fails the test if none arrives within timeout
+func (c *Client) ExpectUpdate(updateType string, timeout time.Duration) *socketify.Update {
+	c.t.Helper()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case message := <-c.updates:
+			var update *socketify.Update
+			if err := json.Unmarshal(message, &update); err != nil || update == nil {
+				continue
+			}
+
+			if update.Type == updateType {
+				return update
+			}
+		case <-deadline.C:
+			c.t.Fatalf("socketifytest: no %q update received within %s", updateType, timeout)
+			return nil
+		}
+	}
+}
+
+// ExpectRaw waits for the next message and returns it, it fails the test if none arrives within timeout
+func (c *Client) ExpectRaw(timeout time.Duration) []byte {
+	c.t.Helper()
+
+	select {
+	case message := <-c.updates:
+		return message
+	case <-time.After(timeout):
+		c.t.Fatalf("socketifytest: no message received within %s", timeout)
+		return nil
+	}
+}
+
+// ExpectNoUpdate fails the test if a message is received within d
+func (c *Client) ExpectNoUpdate(d time.Duration) {
+	c.t.Helper()
+
+	select {
+	case message := <-c.updates:
+		c.t.Fatalf("socketifytest: unexpected message %s", message)
+	case <-time.After(d):
+	}
+}
+
+// SendUpdate writes an update to the server and fails the test if it can't
+func (c *Client) SendUpdate(updateType string, data interface{}, extra ...string) {
+	c.t.Helper()
+
+	if err := c.WriteUpdate(updateType, data, extra...); err != nil {
+		c.t.Fatalf("socketifytest: write %q update: %s", updateType, err)
+	}
+}
+
+// AssertClosedWith fails the test unless the server closes the client with code within DefaultTimeout
+func (c *Client) AssertClosedWith(code int) {
+	c.t.Helper()
+
+	select {
+	case err := <-c.closed:
+		c.closed <- err
+		assertCloseCode(c.t, err, code)
+	case <-time.After(DefaultTimeout):
+		c.t.Fatalf("socketifytest: client not closed within %s", DefaultTimeout)
+	}
+}
+
+// Closed returns the error the client was closed with, or nil if it's still open
+func (c *Client) Closed() error {
+	select {
+	case err := <-c.closed:
+		c.closed <- err
+		return err
+	default:
+		return nil
+	}
+}
+
+func assertCloseCode(t testing.TB, err error, code int) {
+	t.Helper()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("socketifytest: closed with %v, want close code %d", err, code)
+		return
+	}
+
+	if closeErr.Code != code {
+		t.Fatalf("socketifytest: closed with code %d (%q), want %d", closeErr.Code, closeErr.Text, code)
+	}
+}
@@ -0,0 +1,98 @@
+package socketifytest
+
+import (
+	"github.com/aliforever/go-socketify"
+	"sync"
+	"time"
+)
+
+// FakeClock is a socketify.Clock that only moves when Advance is called
+// Pass it to ClientOptions().SetClock to test keepalive without waiting
+type FakeClock struct {
+	m       sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) socketify.Ticker {
+	if d <= 0 {
+		panic("socketifytest: non-positive interval for NewTicker")
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), interval: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+
+	return t
+}
+
+// Advance moves the clock forward by d and fires the tickers that are due
+// Like time.Ticker, a tick is dropped if the previous one wasn't received yet
+func (c *FakeClock) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.now = c.now.Add(d)
+
+	for _, t := range c.tickers {
+		if t.stopped || t.next.After(c.now) {
+			continue
+		}
+
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.interval)
+		}
+
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+}
+
+// Tickers returns the number of running tickers
+func (c *FakeClock) Tickers() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	running := 0
+	for _, t := range c.tickers {
+		if !t.stopped {
+			running++
+		}
+	}
+
+	return running
+}
+
+type fakeTicker struct {
+	clock    *FakeClock
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+	stopped  bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.m.Lock()
+	defer t.clock.m.Unlock()
+
+	t.stopped = true
+}
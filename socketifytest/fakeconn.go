@@ -0,0 +1,248 @@
+package socketifytest
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aliforever/go-socketify"
+	"github.com/gorilla/websocket"
+	"strings"
+	"sync"
+)
+
+type WriteKind string
+
+const (
+	WriteKindUpdate     WriteKind = "update"
+	WriteKindRawUpdate  WriteKind = "raw_update"
+	WriteKindBinary     WriteKind = "binary"
+	WriteKindBinaryText WriteKind = "binary_text"
+	WriteKindText       WriteKind = "text"
+)
+
+// Write is a message written to a FakeConn
+// Type, Data and Extra are only set for updates, Payload is the message as it would be sent
+type Write struct {
+	Kind        WriteKind
+	Type        string
+	Data        interface{}
+	Extra       string
+	Traceparent string
+	Payload     []byte
+}
+
+// DecodeData decodes the data of an update into v, as the client would
+func (w Write) DecodeData(v interface{}) error {
+	b, err := json.Marshal(w.Data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// FakeConn is a socketify.Conn recording every write, it needs no websocket
+type FakeConn struct {
+	id     string
+	logger socketify.Logger
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	m              sync.Mutex
+	writes         []Write
+	attributes     map[string]interface{}
+	attributeHooks []func(change socketify.AttributeChange)
+	writeErr       error
+}
+
+var _ socketify.Conn = (*FakeConn)(nil)
+
+func NewFakeConn(id string) *FakeConn {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	c := &FakeConn{
+		id:         id,
+		logger:     socketify.NopLogger(),
+		cancel:     cancel,
+		attributes: map[string]interface{}{},
+	}
+	c.ctx = socketify.ContextWithConn(ctx, c)
+
+	return c
+}
+
+// SetLogger replaces the default NopLogger
+func (c *FakeConn) SetLogger(l socketify.Logger) *FakeConn {
+	c.logger = l
+	return c
+}
+
+// SetWriteError makes every following write fail with err, nil restores successful writes
+func (c *FakeConn) SetWriteError(err error) *FakeConn {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.writeErr = err
+	return c
+}
+
+func (c *FakeConn) ID() string {
+	return c.id
+}
+
+// Context is cancelled by Close and CloseWithCode, socketify.ConnFromContext returns the FakeConn from it
+func (c *FakeConn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *FakeConn) Logger() socketify.Logger {
+	return c.logger
+}
+
+func (c *FakeConn) WriteUpdate(updateType string, data interface{}, extra ...string) error {
+	return c.WriteUpdateContext(context.Background(), updateType, data, extra...)
+}
+
+func (c *FakeConn) WriteUpdateContext(ctx context.Context, updateType string, data interface{}, extra ...string) error {
+	w := Write{
+		Kind:  WriteKindUpdate,
+		Type:  updateType,
+		Data:  data,
+		Extra: strings.Join(extra, "_"),
+	}
+
+	if span := socketify.SpanFromContext(ctx); span != nil && span.Context.IsValid() {
+		w.Traceparent = span.Context.Traceparent()
+	}
+
+	payload, err := json.Marshal(struct {
+		Type        string      `json:"type"`
+		Data        interface{} `json:"data,omitempty"`
+		Extra       string      `json:"extra,omitempty"`
+		Traceparent string      `json:"traceparent,omitempty"`
+	}{w.Type, w.Data, w.Extra, w.Traceparent})
+	if err != nil {
+		return err
+	}
+	w.Payload = payload
+
+	return c.record(w)
+}
+
+func (c *FakeConn) WriteRawUpdate(data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return c.record(Write{Kind: WriteKindRawUpdate, Data: data, Payload: payload})
+}
+
+func (c *FakeConn) WriteBinaryBytes(data []byte) error {
+	return c.record(Write{Kind: WriteKindBinary, Payload: append([]byte{}, data...)})
+}
+
+func (c *FakeConn) WriteBinaryText(data []byte) error {
+	return c.record(Write{Kind: WriteKindBinaryText, Payload: append([]byte{}, data...)})
+}
+
+func (c *FakeConn) WriteText(data string) error {
+	return c.record(Write{Kind: WriteKindText, Payload: []byte(data)})
+}
+
+func (c *FakeConn) record(w Write) error {
+	if c.ctx.Err() != nil {
+		return socketify.ErrConnectionClosed
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+
+	c.writes = append(c.writes, w)
+
+	return nil
+}
+
+// Writes returns every successful write in order
+func (c *FakeConn) Writes() []Write {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return append([]Write{}, c.writes...)
+}
+
+// Updates returns the updates of updateType written so far, every update if updateType is empty
+func (c *FakeConn) Updates(updateType string) []Write {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var updates []Write
+	for _, w := range c.writes {
+		if w.Kind == WriteKindUpdate && (updateType == "" || w.Type == updateType) {
+			updates = append(updates, w)
+		}
+	}
+
+	return updates
+}
+
+// LastUpdate returns the last update of updateType written so far
+func (c *FakeConn) LastUpdate(updateType string) (Write, bool) {
+	updates := c.Updates(updateType)
+	if len(updates) == 0 {
+		return Write{}, false
+	}
+
+	return updates[len(updates)-1], true
+}
+
+// Reset forgets the writes recorded so far
+func (c *FakeConn) Reset() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.writes = nil
+}
+
+// SetAttribute calls the hooks registered with OnAttributeChange, like Connection.SetAttribute
+func (c *FakeConn) SetAttribute(key string, val interface{}) {
+	c.m.Lock()
+	old, existed := c.attributes[key]
+	c.attributes[key] = val
+	hooks := c.attributeHooks
+	c.m.Unlock()
+
+	for _, hook := range hooks {
+		hook(socketify.AttributeChange{Key: key, Old: old, New: val, Existed: existed})
+	}
+}
+
+func (c *FakeConn) OnAttributeChange(fn func(change socketify.AttributeChange)) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.attributeHooks = append(c.attributeHooks, fn)
+}
+
+func (c *FakeConn) GetAttribute(key string) (val interface{}, exists bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	val, exists = c.attributes[key]
+	return
+}
+
+// Close cancels the context with socketify.ErrConnectionClosed, like Connection.Close
+func (c *FakeConn) Close() error {
+	c.cancel(socketify.ErrConnectionClosed)
+	return nil
+}
+
+// CloseWithCode cancels the context with a *websocket.CloseError, check it with AssertConnectionClosedWith
+func (c *FakeConn) CloseWithCode(code int, text string) error {
+	c.cancel(&websocket.CloseError{Code: code, Text: text})
+	return nil
+}
@@ -0,0 +1,91 @@
+package socketifytest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+// greet is a handler as an application would write it, it only knows socketify.Conn
+func greet(ctx context.Context, g greeting, extra ...string) error {
+	c, _ := socketify.ConnFromContext(ctx)
+
+	if g.Name == "" {
+		return c.CloseWithCode(4000, "anonymous")
+	}
+
+	c.SetAttribute("name", g.Name)
+
+	return c.WriteUpdate("welcome", map[string]string{"message": "Hello " + g.Name}, extra...)
+}
+
+func TestFakeConn(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		writeErr    error
+		wantErr     error
+		wantMessage string
+		wantClose   bool
+	}{
+		{
+			name:        "Writes",
+			data:        `{"name":"Ali"}`,
+			wantMessage: "Hello Ali",
+		},
+		{
+			name:      "Closes",
+			data:      `{}`,
+			wantClose: true,
+		},
+		{
+			name:     "WriteError",
+			data:     `{"name":"Ali"}`,
+			writeErr: socketify.ErrConnectionClosed,
+			wantErr:  socketify.ErrConnectionClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := socketifytest.NewFakeConn("1").SetWriteError(tt.writeErr)
+
+			err := socketify.DataMapperContext[greeting](greet).Handle(conn.Context(), json.RawMessage(tt.data), "42")
+			assert.True(t, errors.Is(err, tt.wantErr), "got error %v", err)
+
+			if tt.wantClose {
+				socketifytest.AssertConnectionClosedWith(t, conn, 4000)
+				assert.ErrorIs(t, conn.WriteText("late"), socketify.ErrConnectionClosed)
+				assert.Empty(t, conn.Writes())
+				return
+			}
+
+			if tt.wantErr != nil {
+				assert.Empty(t, conn.Writes())
+				return
+			}
+
+			update, ok := conn.LastUpdate("welcome")
+			assert.True(t, ok)
+			assert.Equal(t, "42", update.Extra)
+			assert.JSONEq(t, `{"type":"welcome","data":{"message":"`+tt.wantMessage+`"},"extra":"42"}`, string(update.Payload))
+
+			var data struct {
+				Message string `json:"message"`
+			}
+			assert.NoError(t, update.DecodeData(&data))
+			assert.Equal(t, tt.wantMessage, data.Message)
+
+			name, _ := conn.GetAttribute("name")
+			assert.Equal(t, "Ali", name)
+		})
+	}
+}
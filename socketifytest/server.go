@@ -0,0 +1,198 @@
+// Package socketifytest runs socketify servers in tests, on an ephemeral port or in memory over net.Pipe
+package socketifytest
+
+import (
+	"context"
+	"github.com/aliforever/go-socketify"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// DefaultTimeout bounds how long the harness waits for connections, updates and closes
+var DefaultTimeout = 5 * time.Second
+
+// Server serves a *socketify.Server, it upgrades every request and calls the handler with the new connection
+// before processing its updates
+type Server struct {
+	*socketify.Server
+
+	// URL is the websocket URL of the server, e.g. ws://127.0.0.1:41234
+	URL string
+
+	t       testing.TB
+	handler func(c *socketify.Connection)
+	dialer  *websocket.Dialer
+
+	dialLock    sync.Mutex
+	connections chan *pendingConnection
+	done        chan struct{}
+}
+
+type pendingConnection struct {
+	connection *socketify.Connection
+	ready      chan struct{}
+}
+
+// NewServer serves server on an ephemeral port of the loopback interface until the test ends
+// handler may be nil
+func NewServer(t testing.TB, server *socketify.Server, handler func(c *socketify.Connection)) *Server {
+	t.Helper()
+
+	s := newServer(t, server, handler)
+
+	hs := httptest.NewServer(server)
+	t.Cleanup(hs.Close)
+
+	s.URL = "ws" + strings.TrimPrefix(hs.URL, "http")
+	s.dialer = &websocket.Dialer{HandshakeTimeout: DefaultTimeout}
+
+	return s
+}
+
+// NewPipeServer serves server in memory, clients connect over net.Pipe so no port is used
+func NewPipeServer(t testing.TB, server *socketify.Server, handler func(c *socketify.Connection)) *Server {
+	t.Helper()
+
+	s := newServer(t, server, handler)
+
+	listener := newPipeListener()
+	hs := &http.Server{Handler: server}
+	go hs.Serve(listener)
+	t.Cleanup(func() {
+		_ = hs.Close()
+	})
+
+	s.URL = "ws://pipe"
+	s.dialer = &websocket.Dialer{HandshakeTimeout: DefaultTimeout, NetDialContext: listener.DialContext}
+
+	return s
+}
+
+func newServer(t testing.TB, server *socketify.Server, handler func(c *socketify.Connection)) *Server {
+	s := &Server{
+		Server:      server,
+		t:           t,
+		handler:     handler,
+		connections: make(chan *pendingConnection, 16),
+		done:        make(chan struct{}),
+	}
+
+	t.Cleanup(func() {
+		close(s.done)
+	})
+
+	go s.upgrade()
+
+	return s
+}
+
+func (s *Server) upgrade() {
+	for {
+		var request *socketify.UpgradeRequest
+
+		select {
+		case request = <-s.UpgradeRequests():
+		case <-s.done:
+			return
+		}
+
+		connection, err := request.Upgrade()
+		if err != nil {
+			continue
+		}
+
+		pending := &pendingConnection{connection: connection, ready: make(chan struct{})}
+
+		go func() {
+			select {
+			case <-pending.ready:
+			case <-s.done:
+				_ = connection.Close()
+				return
+			}
+
+			if s.handler != nil {
+				s.handler(connection)
+			}
+
+			_ = connection.ProcessUpdates()
+		}()
+
+		select {
+		case s.connections <- pending:
+		case <-s.done:
+			_ = connection.Close()
+			return
+		}
+	}
+}
+
+// Dialer returns the dialer clients must use to reach the server, pass it to ClientOptions().SetDialer
+func (s *Server) Dialer() *websocket.Dialer {
+	return s.dialer
+}
+
+// Pair connects a client with default options and returns it with its server side connection
+func (s *Server) Pair() (*Client, *socketify.Connection) {
+	s.t.Helper()
+
+	return s.PairWith(func(url string, dialer *websocket.Dialer) (*socketify.Client, error) {
+		return socketify.NewClientWithOptions(url, socketify.ClientOptions().SetDialer(dialer))
+	})
+}
+
+// PairWith is like Pair but the client is created by dial, e.g. to set client options:
+//
+//	server.PairWith(func(url string, dialer *websocket.Dialer) (*socketify.Client, error) {
+//		return socketify.NewClientWithOptions(url, socketify.ClientOptions().SetDialer(dialer).SetKeepAlive(time.Second, 0))
+//	})
+//
+// The server's handler runs, and the connection starts processing updates, once the client is ready to receive
+func (s *Server) PairWith(dial func(url string, dialer *websocket.Dialer) (*socketify.Client, error)) (*Client, *socketify.Connection) {
+	s.t.Helper()
+
+	s.dialLock.Lock()
+	defer s.dialLock.Unlock()
+
+	client, err := dial(s.URL, s.dialer)
+	if err != nil {
+		s.t.Fatalf("socketifytest: dial %s: %s", s.URL, err)
+	}
+
+	var pending *pendingConnection
+	select {
+	case pending = <-s.connections:
+	case <-time.After(DefaultTimeout):
+		client.Close(websocket.CloseNormalClosure, "")
+		s.t.Fatalf("socketifytest: connection not upgraded within %s", DefaultTimeout)
+	}
+
+	c := newClient(s.t, client)
+	close(pending.ready)
+
+	s.t.Cleanup(func() {
+		client.Close(websocket.CloseNormalClosure, "")
+		_ = pending.connection.Close()
+	})
+
+	return c, pending.connection
+}
+
+// AssertConnectionClosedWith fails the test unless connection is closed with a close frame carrying code
+// within DefaultTimeout, either sent by the client or by CloseWithCode, connection may be a FakeConn
+func AssertConnectionClosedWith(t testing.TB, connection socketify.Conn, code int) {
+	t.Helper()
+
+	select {
+	case <-connection.Context().Done():
+	case <-time.After(DefaultTimeout):
+		t.Fatalf("socketifytest: connection %s not closed within %s", connection.ID(), DefaultTimeout)
+	}
+
+	assertCloseCode(t, context.Cause(connection.Context()), code)
+}
@@ -1,18 +1,51 @@
 package socketify
 
-import "sync"
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
 
 // TODO: Some methods should not be exported when we are allowing direct external access to clients
 
 type storage struct {
 	m       sync.Mutex
 	clients map[string]*Connection
+	// subscriptions are indexed by topic then by connection ID
+	subscriptions map[string]map[string]*topicSubscriber
+	// rooms map room names to their members by connection ID
+	rooms map[string]map[string]*Connection
+	// topicsOf and roomsOf index subscriptions and rooms by connection, so closing a connection only visits its own
+	topicsOf map[*Connection]map[string]struct{}
+	roomsOf  map[*Connection]map[string]struct{}
+
+	// indexes map the attribute keys passed to IndexAttributes to their values, then to connections by ID
+	// They're only changed while holding the attributes lock of the connection, see Connection.SetAttribute
+	indexM  sync.RWMutex
+	indexes map[string]map[interface{}]map[string]*Connection
+}
+
+// topicSubscriber holds the subscriptions of a connection to a topic, by key
+type topicSubscriber struct {
+	connection *Connection
+	params     map[string]interface{}
 }
 
-func newStorage() *storage {
-	return &storage{
-		clients: map[string]*Connection{},
+func newStorage(indexedKeys []string) *storage {
+	s := &storage{
+		clients:       map[string]*Connection{},
+		subscriptions: map[string]map[string]*topicSubscriber{},
+		rooms:         map[string]map[string]*Connection{},
+		topicsOf:      map[*Connection]map[string]struct{}{},
+		roomsOf:       map[*Connection]map[string]struct{}{},
+		indexes:       map[string]map[interface{}]map[string]*Connection{},
 	}
+
+	for _, key := range indexedKeys {
+		s.indexes[key] = map[interface{}]map[string]*Connection{}
+	}
+
+	return s
 }
 
 func (s *storage) GetClientByID(clientID string) *Connection {
@@ -22,13 +55,31 @@ func (s *storage) GetClientByID(clientID string) *Connection {
 	return s.clients[clientID]
 }
 
-func (s *storage) GetClientsByAttributeValue(key, value string) []*Connection {
+// GetClientsByAttributeValue returns the connections whose attribute key equals value, values of different types
+// are never equal (int(1) isn't int64(1)). Keys passed to options.IndexAttributes are looked up without scanning
+func (s *storage) GetClientsByAttributeValue(key string, value interface{}) []*Connection {
+	if !isComparable(value) {
+		return nil
+	}
+
+	if s.isIndexed(key) {
+		s.indexM.RLock()
+		defer s.indexM.RUnlock()
+
+		var clients []*Connection
+		for _, client := range s.indexes[key][value] {
+			clients = append(clients, client)
+		}
+
+		return clients
+	}
+
 	s.m.Lock()
 	defer s.m.Unlock()
 
 	var clients []*Connection
 	for index, client := range s.clients {
-		if val, exists := client.GetAttribute(key); exists {
+		if val, exists := client.GetAttribute(key); exists && isComparable(val) {
 			if val == value {
 				clients = append(clients, s.clients[index])
 			}
@@ -38,6 +89,55 @@ func (s *storage) GetClientsByAttributeValue(key, value string) []*Connection {
 	return clients
 }
 
+// isComparable reports whether v can be compared with == and used as a map key without panicking
+// It checks the value, a struct type with an interface field is comparable but panics if the field holds a slice
+func isComparable(v interface{}) bool {
+	return v == nil || reflect.ValueOf(v).Comparable()
+}
+
+// isIndexed is safe without a lock, the set of indexed keys never changes
+func (s *storage) isIndexed(key string) bool {
+	_, indexed := s.indexes[key]
+	return indexed
+}
+
+// index adds c to the index of key for value, the caller holds c's attributes lock
+func (s *storage) index(c *Connection, key string, value interface{}) {
+	if !s.isIndexed(key) || !isComparable(value) {
+		return
+	}
+
+	s.indexM.Lock()
+	defer s.indexM.Unlock()
+
+	clients := s.indexes[key][value]
+	if clients == nil {
+		clients = map[string]*Connection{}
+		s.indexes[key][value] = clients
+	}
+	clients[c.id] = c
+}
+
+// unindex removes c from the index of key for value, the caller holds c's attributes lock
+func (s *storage) unindex(c *Connection, key string, value interface{}) {
+	if !s.isIndexed(key) || !isComparable(value) {
+		return
+	}
+
+	s.indexM.Lock()
+	defer s.indexM.Unlock()
+
+	clients := s.indexes[key][value]
+	if clients[c.id] != c {
+		return
+	}
+
+	delete(clients, c.id)
+	if len(clients) == 0 {
+		delete(s.indexes[key], value)
+	}
+}
+
 func (s *storage) ClientIDs() (ids []string) {
 	s.m.Lock()
 	defer s.m.Unlock()
@@ -50,15 +150,171 @@ func (s *storage) ClientIDs() (ids []string) {
 }
 
 func (s *storage) addClient(c *Connection) {
+	s.m.Lock()
+	s.clients[c.id] = c
+	s.m.Unlock()
+
+	c.attributesLocker.Lock()
+	defer c.attributesLocker.Unlock()
+
+	c.indexed = true
+	for key, value := range c.attributes {
+		s.index(c, key, value)
+	}
+}
+
+// removeClient removes c with its subscriptions and rooms, a new connection with the same ID is left alone
+func (s *storage) removeClient(c *Connection) {
+	c.attributesLocker.Lock()
+	if c.indexed {
+		c.indexed = false
+		for key, value := range c.attributes {
+			s.unindex(c, key, value)
+		}
+	}
+	c.attributesLocker.Unlock()
+
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	s.clients[c.id] = c
+	if s.clients[c.id] == c {
+		delete(s.clients, c.id)
+	}
+
+	for topic := range s.topicsOf[c] {
+		s.removeSubscriber(topic, c)
+	}
+	delete(s.topicsOf, c)
+
+	for room := range s.roomsOf[c] {
+		s.removeMember(room, c)
+	}
+	delete(s.roomsOf, c)
+}
+
+// addMembership adds name to the set of topics or rooms of c, the caller holds s.m
+func addMembership(index map[*Connection]map[string]struct{}, c *Connection, name string) {
+	names := index[c]
+	if names == nil {
+		names = map[string]struct{}{}
+		index[c] = names
+	}
+	names[name] = struct{}{}
+}
+
+// removeMembership removes name from the set of topics or rooms of c, the caller holds s.m
+func removeMembership(index map[*Connection]map[string]struct{}, c *Connection, name string) {
+	delete(index[c], name)
+	if len(index[c]) == 0 {
+		delete(index, c)
+	}
+}
+
+// sortedMemberships returns the topics or rooms of c, the caller holds s.m
+func sortedMemberships(index map[*Connection]map[string]struct{}, c *Connection) []string {
+	if len(index[c]) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(index[c]))
+	for name := range index[c] {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// removeSubscriber removes every subscription of c to topic, the caller holds s.m
+func (s *storage) removeSubscriber(topic string, c *Connection) {
+	subscribers := s.subscriptions[topic]
+	if subscriber := subscribers[c.id]; subscriber == nil || subscriber.connection != c {
+		return
+	}
+
+	delete(subscribers, c.id)
+	if len(subscribers) == 0 {
+		delete(s.subscriptions, topic)
+	}
+}
+
+// subscribe adds a subscription of c to topic, it fails if c was already removed from the storage
+func (s *storage) subscribe(topic string, c *Connection, key string, params interface{}) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.clients[c.id] != c {
+		return ErrConnectionClosed
+	}
+
+	subscribers := s.subscriptions[topic]
+	if subscribers == nil {
+		subscribers = map[string]*topicSubscriber{}
+		s.subscriptions[topic] = subscribers
+	}
+
+	subscriber := subscribers[c.id]
+	if subscriber == nil {
+		subscriber = &topicSubscriber{connection: c, params: map[string]interface{}{}}
+		subscribers[c.id] = subscriber
+	}
+
+	subscriber.params[key] = params
+	addMembership(s.topicsOf, c, topic)
+
+	return nil
+}
+
+// unsubscribe removes the subscription with key, or every subscription of the connection to topic if key is empty
+// It returns the number of subscriptions removed
+func (s *storage) unsubscribe(topic string, c *Connection, key string) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	subscriber := s.subscriptions[topic][c.id]
+	if subscriber == nil || subscriber.connection != c {
+		return 0
+	}
+
+	removed := len(subscriber.params)
+	if key == "" {
+		subscriber.params = map[string]interface{}{}
+	} else if _, exists := subscriber.params[key]; exists {
+		delete(subscriber.params, key)
+		removed = 1
+	} else {
+		removed = 0
+	}
+
+	if len(subscriber.params) == 0 {
+		s.removeSubscriber(topic, c)
+		removeMembership(s.topicsOf, c, topic)
+	}
+
+	return removed
+}
+
+// subscribers returns the params of every subscription to topic by connection
+func (s *storage) subscribers(topic string) map[*Connection][]interface{} {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	subscribers := make(map[*Connection][]interface{}, len(s.subscriptions[topic]))
+	for _, subscriber := range s.subscriptions[topic] {
+		params := make([]interface{}, 0, len(subscriber.params))
+		for _, p := range subscriber.params {
+			params = append(params, p)
+		}
+		subscribers[subscriber.connection] = params
+	}
+
+	return subscribers
 }
 
-func (s *storage) removeClientByID(clientID string) {
+func (s *storage) topicsOfConnection(c *Connection) []string {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	delete(s.clients, clientID)
+	return sortedMemberships(s.topicsOf, c)
 }
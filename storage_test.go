@@ -0,0 +1,149 @@
+package socketify_test
+
+import (
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetClientsByAttributeValue(t *testing.T) {
+	server := socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).IndexAttributes("user_id", "tags"))
+	ts := socketifytest.NewPipeServer(t, server, nil)
+
+	_, first := ts.Pair()
+	_, second := ts.Pair()
+	third, thirdConnection := ts.Pair()
+
+	first.SetAttribute("user_id", 1)
+	first.SetAttribute("role", "admin")
+	second.SetAttribute("user_id", 2)
+	second.SetAttribute("role", "admin")
+	second.SetAttribute("tags", []string{"beta"})
+	thirdConnection.SetAttribute("user_id", 1)
+	// Indexing a struct holding a slice in an interface field would panic
+	thirdConnection.SetAttribute("tags", struct{ X interface{} }{X: []int{}})
+
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		want  []string
+	}{
+		{name: "Indexed", key: "user_id", value: 1, want: []string{first.ID(), thirdConnection.ID()}},
+		{name: "IndexedOtherType", key: "user_id", value: int64(1), want: nil},
+		{name: "NotIndexed", key: "role", value: "admin", want: []string{first.ID(), second.ID()}},
+		{name: "Missing", key: "user_id", value: 3, want: nil},
+		{name: "NotComparable", key: "tags", value: []string{"beta"}, want: nil},
+		{name: "NotComparableInterfaceField", key: "role", value: struct{ X interface{} }{X: []int{}}, want: nil},
+		{name: "NotComparableInterfaceFieldIndexed", key: "user_id", value: struct{ X interface{} }{X: []int{}}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sort.Strings(tt.want)
+			assert.Equal(t, tt.want, ids(server.Storage().GetClientsByAttributeValue(tt.key, tt.value)))
+		})
+	}
+
+	t.Run("Updated", func(t *testing.T) {
+		first.SetAttribute("user_id", 3)
+
+		assert.Equal(t, []string{thirdConnection.ID()}, ids(server.Storage().GetClientsByAttributeValue("user_id", 1)))
+		assert.Equal(t, []string{first.ID()}, ids(server.Storage().GetClientsByAttributeValue("user_id", 3)))
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		third.Close(websocket.CloseNormalClosure, "")
+		socketifytest.AssertConnectionClosedWith(t, thirdConnection, websocket.CloseNormalClosure)
+
+		assert.Eventually(t, func() bool {
+			return len(server.Storage().GetClientsByAttributeValue("user_id", 1)) == 0
+		}, time.Second, time.Millisecond*10)
+	})
+}
+
+func ids(connections []*socketify.Connection) []string {
+	var ids []string
+	for _, c := range connections {
+		ids = append(ids, c.ID())
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// sameIDConnections upgrades two connections with the ID "same", the second one replaces the first in the storage
+func sameIDConnections(t *testing.T, server *socketify.Server) (first, second *socketify.Connection) {
+	hs := httptest.NewServer(server)
+	t.Cleanup(hs.Close)
+
+	connections := make(chan *socketify.Connection, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			connection, err := (<-server.UpgradeRequests()).SetClientID("same").Upgrade()
+			if err != nil {
+				close(connections)
+				return
+			}
+			t.Cleanup(func() {
+				_ = connection.Close()
+			})
+			go connection.ProcessUpdates()
+			connections <- connection
+		}
+	}()
+
+	dial := func() *socketify.Connection {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		select {
+		case connection := <-connections:
+			if connection == nil {
+				t.Fatal("upgrade failed")
+			}
+			return connection
+		case <-time.After(time.Second):
+			t.Fatal("connection not upgraded")
+		}
+
+		return nil
+	}
+
+	return dial(), dial()
+}
+
+func TestStaleCloseOfReusedID(t *testing.T) {
+	server := socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).EnableTopics().IndexAttributes("user_id"))
+	orders := socketify.NewTopic[orderParams, order](server, "orders")
+
+	first, second := sameIDConnections(t, server)
+
+	second.SetAttribute("user_id", 1)
+	assert.NoError(t, orders.Subscribe(second, orderParams{}))
+
+	// The first connection was replaced, it can't subscribe anymore and closing it leaves the second one alone
+	assert.ErrorIs(t, orders.Subscribe(first, orderParams{}), socketify.ErrConnectionClosed)
+	assert.False(t, orders.Unsubscribe(first, orderParams{}))
+	assert.NoError(t, first.Close())
+
+	assert.Same(t, second, server.Storage().GetClientByID("same"))
+	assert.Equal(t, []string{"orders"}, second.Topics())
+	assert.Equal(t, 1, orders.Subscribers())
+	assert.Len(t, server.Storage().GetClientsByAttributeValue("user_id", 1), 1)
+
+	assert.NoError(t, second.Close())
+	assert.Nil(t, second.Topics())
+	assert.Equal(t, 0, orders.Subscribers())
+}
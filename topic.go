@@ -0,0 +1,406 @@
+package socketify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Update types of the built-in topic handlers and of their replies
+const (
+	SubscribeUpdateType    = "subscribe"
+	UnsubscribeUpdateType  = "unsubscribe"
+	SubscribedUpdateType   = "subscribed"
+	UnsubscribedUpdateType = "unsubscribed"
+)
+
+const ErrorCodeUnknownTopic = "unknown_topic"
+
+// TopicRequest is the data of subscribe and unsubscribe updates and of their replies
+// e.g. {"type": "subscribe", "data": {"topic": "orders", "params": {"symbol": "BTC"}}}
+type TopicRequest struct {
+	Topic  string          `json:"topic"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// topicHandler is the untyped side of a Topic, used by the built-in handlers
+type topicHandler interface {
+	subscribe(c *Connection, params json.RawMessage) (key string, err error)
+	unsubscribe(c *Connection, params json.RawMessage) (key string, err error)
+}
+
+type topicRegistry struct {
+	m      sync.RWMutex
+	topics map[string]topicHandler
+}
+
+func newTopicRegistry() *topicRegistry {
+	return &topicRegistry{topics: map[string]topicHandler{}}
+}
+
+func (r *topicRegistry) get(name string) topicHandler {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.topics[name]
+}
+
+func (r *topicRegistry) add(name string, t topicHandler) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, exists := r.topics[name]; exists {
+		panic(fmt.Sprintf("socketify: topic %q already exists", name))
+	}
+
+	r.topics[name] = t
+}
+
+// Topic is a stream of events of type E, clients subscribe to it with parameters of type P
+// Events are sent as updates whose type is the topic's name
+type Topic[P any, E any] struct {
+	name      string
+	server    *Server
+	filter    func(params P, event E) bool
+	authorize func(c *Connection, params P) error
+}
+
+// NewTopic registers a topic clients can subscribe to, it panics if the server wasn't created with EnableTopics
+// or if a topic with the same name exists
+func NewTopic[P any, E any](s *Server, name string) *Topic[P, E] {
+	if s.topics == nil {
+		panic("socketify: NewTopic requires ServerOptions().EnableTopics()")
+	}
+
+	t := &Topic[P, E]{name: name, server: s}
+	s.topics.add(name, t)
+
+	if s.opts.broker != nil {
+		t.subscribeBroker()
+	}
+
+	return t
+}
+
+func (t *Topic[P, E]) Name() string {
+	return t.name
+}
+
+// SetFilter sets the predicate deciding if an event is sent to a subscription, by default every subscription gets every event
+func (t *Topic[P, E]) SetFilter(filter func(params P, event E) bool) *Topic[P, E] {
+	t.filter = filter
+	return t
+}
+
+// SetAuthorize sets a check run before a connection subscribes, returning an *Error controls what the client is told
+func (t *Topic[P, E]) SetAuthorize(authorize func(c *Connection, params P) error) *Topic[P, E] {
+	t.authorize = authorize
+	return t
+}
+
+// Subscribe subscribes c on the server side, as if the client sent a subscribe update
+func (t *Topic[P, E]) Subscribe(c *Connection, params P) error {
+	key, err := subscriptionKey(params)
+	if err != nil {
+		return err
+	}
+
+	return t.server.storage.subscribe(t.name, c, key, params)
+}
+
+// Unsubscribe removes the subscription of c with params, it reports whether it existed
+func (t *Topic[P, E]) Unsubscribe(c *Connection, params P) bool {
+	key, err := subscriptionKey(params)
+	if err != nil {
+		return false
+	}
+
+	return t.server.storage.unsubscribe(t.name, c, key) > 0
+}
+
+// Subscribers returns the number of connections subscribed to the topic
+func (t *Topic[P, E]) Subscribers() int {
+	return len(t.server.storage.subscribers(t.name))
+}
+
+// Publish sends event to every connection with a subscription matching the filter
+// A connection subscribed several times receives the event once, Publish returns the number of connections of this instance
+// it was sent to. With a broker, the event is then published to the other instances
+func (t *Topic[P, E]) Publish(event E) int {
+	return t.PublishContext(context.Background(), event)
+}
+
+// PublishContext is like Publish but the updates continue the trace of the span in ctx
+func (t *Topic[P, E]) PublishContext(ctx context.Context, event E) int {
+	sent := t.publishLocal(ctx, event)
+
+	if broker := t.server.opts.broker; broker != nil {
+		message, err := t.server.newBrokerMessage(ctx, t.name, event)
+		if err == nil {
+			err = broker.Publish(ctx, topicChannel(t.name), message)
+		}
+		if err != nil {
+			t.server.opts.logger.Error("Error publishing to broker", "topic", t.name, "error", err)
+		}
+	}
+
+	return sent
+}
+
+// subscribeBroker receives the events published to the topic by other instances
+func (t *Topic[P, E]) subscribeBroker() {
+	_, err := t.server.opts.broker.Subscribe(topicChannel(t.name), func(b []byte) {
+		ctx, message, ok := t.server.decodeBrokerMessage(b)
+		if !ok || message.Node == t.server.opts.nodeID {
+			return
+		}
+
+		var event E
+		if err := json.Unmarshal(message.Data, &event); err != nil {
+			t.server.opts.logger.Warn("Invalid topic event from broker", "topic", t.name, "error", err)
+			return
+		}
+
+		for c, params := range t.server.storage.subscribers(t.name) {
+			if t.matches(params, event) {
+				c.queueBrokerUpdate(ctx, t.name, event)
+			}
+		}
+	})
+	if err != nil {
+		t.server.opts.logger.Error("Error subscribing to broker", "channel", topicChannel(t.name), "error", err)
+	}
+}
+
+func (t *Topic[P, E]) publishLocal(ctx context.Context, event E) int {
+	var (
+		wg   sync.WaitGroup
+		sent int64
+	)
+
+	for c, params := range t.server.storage.subscribers(t.name) {
+		if !t.matches(params, event) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(c *Connection) {
+			defer wg.Done()
+
+			if err := c.WriteUpdateContext(ctx, t.name, event); err != nil {
+				c.logger.Warn("Error publishing to topic", "topic", t.name, "error", err)
+				return
+			}
+			atomic.AddInt64(&sent, 1)
+		}(c)
+	}
+
+	wg.Wait()
+
+	return int(sent)
+}
+
+func (t *Topic[P, E]) matches(params []interface{}, event E) bool {
+	if t.filter == nil {
+		return true
+	}
+
+	for _, p := range params {
+		if t.filter(p.(P), event) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Topic[P, E]) decodeParams(raw json.RawMessage) (params P, key string, err error) {
+	if len(raw) > 0 {
+		if err = json.Unmarshal(raw, &params); err != nil {
+			return params, "", NewError(ErrorCodeBadRequest, "invalid topic params")
+		}
+	}
+
+	key, err = subscriptionKey(params)
+	return
+}
+
+func (t *Topic[P, E]) subscribe(c *Connection, raw json.RawMessage) (string, error) {
+	params, key, err := t.decodeParams(raw)
+	if err != nil {
+		return "", err
+	}
+
+	if t.authorize != nil {
+		if err := t.authorize(c, params); err != nil {
+			return "", err
+		}
+	}
+
+	return key, t.server.storage.subscribe(t.name, c, key, params)
+}
+
+func (t *Topic[P, E]) unsubscribe(c *Connection, raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		t.server.storage.unsubscribe(t.name, c, "")
+		return "", nil
+	}
+
+	_, key, err := t.decodeParams(raw)
+	if err != nil {
+		return "", err
+	}
+
+	t.server.storage.unsubscribe(t.name, c, key)
+
+	return key, nil
+}
+
+// subscriptionKey identifies a subscription by its parameters, re-encoded so equal parameters give equal keys
+func subscriptionKey(params interface{}) (string, error) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// handleSubscribe is the built-in handler of subscribe updates
+func (c *Connection) handleSubscribe(ctx context.Context, request TopicRequest, extra ...string) error {
+	t := c.server.topics.get(request.Topic)
+	if t == nil {
+		return NewError(ErrorCodeUnknownTopic, "unknown topic").WithDetails(map[string]string{"topic": request.Topic})
+	}
+
+	key, err := t.subscribe(c, request.Params)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteUpdateContext(ctx, SubscribedUpdateType, TopicRequest{Topic: request.Topic, Params: json.RawMessage(key)}, extra...)
+}
+
+// handleUnsubscribe is the built-in handler of unsubscribe updates, without params every subscription to the topic is removed
+func (c *Connection) handleUnsubscribe(ctx context.Context, request TopicRequest, extra ...string) error {
+	t := c.server.topics.get(request.Topic)
+	if t == nil {
+		return NewError(ErrorCodeUnknownTopic, "unknown topic").WithDetails(map[string]string{"topic": request.Topic})
+	}
+
+	key, err := t.unsubscribe(c, request.Params)
+	if err != nil {
+		return err
+	}
+
+	reply := TopicRequest{Topic: request.Topic}
+	if key != "" {
+		reply.Params = json.RawMessage(key)
+	}
+
+	return c.WriteUpdateContext(ctx, UnsubscribedUpdateType, reply, extra...)
+}
+
+// Topics returns the topics c is subscribed to
+func (c *Connection) Topics() []string {
+	if c.server.storage == nil {
+		return nil
+	}
+
+	return c.server.storage.topicsOfConnection(c)
+}
+
+// Subscriptions remembers the subscriptions of a client, so they can be sent again to the new client after a reconnect
+type Subscriptions struct {
+	m       sync.Mutex
+	client  *Client
+	entries []TopicRequest
+}
+
+func NewSubscriptions() *Subscriptions {
+	return &Subscriptions{}
+}
+
+// Subscribe adds a subscription, it's sent right away if a client is attached
+func (s *Subscriptions) Subscribe(topic string, params interface{}) error {
+	request, err := newTopicRequest(topic, params)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, entry := range s.entries {
+		if entry.Topic == request.Topic && string(entry.Params) == string(request.Params) {
+			return nil
+		}
+	}
+	s.entries = append(s.entries, request)
+
+	if s.client == nil {
+		return nil
+	}
+
+	return s.client.WriteUpdate(SubscribeUpdateType, request)
+}
+
+// Unsubscribe removes a subscription, nil params remove every subscription to topic
+func (s *Subscriptions) Unsubscribe(topic string, params interface{}) error {
+	request, err := newTopicRequest(topic, params)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	entries := s.entries[:0]
+	for _, entry := range s.entries {
+		if entry.Topic == request.Topic && (request.Params == nil || string(entry.Params) == string(request.Params)) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	s.entries = entries
+
+	if s.client == nil {
+		return nil
+	}
+
+	return s.client.WriteUpdate(UnsubscribeUpdateType, request)
+}
+
+// Attach sends every subscription to cl and sends the next ones to it, call it after each (re)connect
+func (s *Subscriptions) Attach(cl *Client) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.client = cl
+
+	for _, entry := range s.entries {
+		if err := cl.WriteUpdate(SubscribeUpdateType, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newTopicRequest(topic string, params interface{}) (TopicRequest, error) {
+	request := TopicRequest{Topic: topic}
+
+	if params == nil {
+		return request, nil
+	}
+
+	b, err := json.Marshal(params)
+	if err != nil {
+		return request, err
+	}
+	request.Params = b
+
+	return request, nil
+}
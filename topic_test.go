@@ -0,0 +1,103 @@
+package socketify_test
+
+import (
+	"encoding/json"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type orderParams struct {
+	Symbol string `json:"symbol"`
+}
+
+type order struct {
+	Symbol string `json:"symbol"`
+	Price  int    `json:"price"`
+}
+
+func TestTopic(t *testing.T) {
+	server := socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).EnableTopics().EnableErrorReplies())
+
+	orders := socketify.NewTopic[orderParams, order](server, "orders").
+		SetFilter(func(p orderParams, o order) bool {
+			return p.Symbol == "" || p.Symbol == o.Symbol
+		})
+
+	ts := socketifytest.NewPipeServer(t, server, nil)
+
+	btc, btcConnection := ts.Pair()
+	all, _ := ts.Pair()
+
+	subscriptions := socketify.NewSubscriptions()
+	assert.NoError(t, subscriptions.Subscribe("orders", orderParams{Symbol: "BTC"}))
+	assert.NoError(t, subscriptions.Attach(btc.Client))
+	btc.ExpectUpdate(socketify.SubscribedUpdateType, time.Second)
+
+	all.SendUpdate(socketify.SubscribeUpdateType, socketify.TopicRequest{Topic: "orders"})
+	all.ExpectUpdate(socketify.SubscribedUpdateType, time.Second)
+
+	all.SendUpdate(socketify.SubscribeUpdateType, socketify.TopicRequest{Topic: "trades"})
+	reply := all.ExpectUpdate("error", time.Second)
+	assert.Contains(t, string(reply.Data), socketify.ErrorCodeUnknownTopic)
+
+	assert.Equal(t, 2, orders.Subscribers())
+	assert.Equal(t, []string{"orders"}, btcConnection.Topics())
+
+	tests := []struct {
+		name    string
+		event   order
+		wantBTC bool
+	}{
+		{
+			name:    "Matching",
+			event:   order{Symbol: "BTC", Price: 100},
+			wantBTC: true,
+		},
+		{
+			name:    "Filtered",
+			event:   order{Symbol: "ETH", Price: 10},
+			wantBTC: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := 1
+			if tt.wantBTC {
+				want = 2
+			}
+			assert.Equal(t, want, orders.Publish(tt.event))
+
+			var got order
+			assert.NoError(t, json.Unmarshal(all.ExpectUpdate("orders", time.Second).Data, &got))
+			assert.Equal(t, tt.event, got)
+
+			if tt.wantBTC {
+				btc.ExpectUpdate("orders", time.Second)
+			} else {
+				btc.ExpectNoUpdate(time.Millisecond * 50)
+			}
+		})
+	}
+
+	btc.Close(websocket.CloseNormalClosure, "reconnecting")
+	socketifytest.AssertConnectionClosedWith(t, btcConnection, websocket.CloseNormalClosure)
+	assert.Eventually(t, func() bool {
+		return orders.Subscribers() == 1
+	}, time.Second, time.Millisecond*10)
+
+	reconnected, _ := ts.Pair()
+	assert.NoError(t, subscriptions.Attach(reconnected.Client))
+	reconnected.ExpectUpdate(socketify.SubscribedUpdateType, time.Second)
+
+	assert.Equal(t, 2, orders.Publish(order{Symbol: "BTC"}))
+	reconnected.ExpectUpdate("orders", time.Second)
+
+	all.SendUpdate(socketify.UnsubscribeUpdateType, socketify.TopicRequest{Topic: "orders"})
+	all.ExpectUpdate(socketify.UnsubscribedUpdateType, time.Second)
+	assert.Equal(t, 1, orders.Subscribers())
+}
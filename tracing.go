@@ -0,0 +1,267 @@
+package socketify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidTraceparent = errors.New("invalid_traceparent")
+
+// SpanContext identifies a span, it's propagated in the "traceparent" field of updates using the W3C Trace Context format
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a W3C traceparent header value: 00-<trace id>-<span id>-<flags>
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+func ParseTraceparent(traceparent string) (sc SpanContext, err error) {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, ErrInvalidTraceparent
+	}
+
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceparent
+	}
+
+	var flags [1]byte
+	if _, err = hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err = hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err = hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, nil
+}
+
+type SpanKind string
+
+const (
+	// SpanKindConsumer is the kind of spans started for incoming updates
+	SpanKindConsumer SpanKind = "consumer"
+	// SpanKindProducer is the kind of spans started for outgoing updates
+	SpanKindProducer SpanKind = "producer"
+)
+
+// Span is a timed operation, socketify starts one for every incoming update and every WriteUpdate when a SpanExporter is set
+// Spans are passed to the exporter once they end and must not be modified after that
+type Span struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanContext
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]interface{}
+	Err        error
+
+	m        sync.Mutex
+	ended    bool
+	exporter SpanExporter
+}
+
+// SetAttribute adds an attribute to the span, it's a no-op on ended or nil spans
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.ended {
+		if s.Attributes == nil {
+			s.Attributes = map[string]interface{}{}
+		}
+		s.Attributes[key] = value
+	}
+}
+
+// RecordError marks the span as failed, it's a no-op on ended or nil spans
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.ended {
+		s.Err = err
+	}
+}
+
+// End ends the span and exports it if it's sampled, only the first call has an effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.m.Lock()
+	if s.ended {
+		s.m.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.m.Unlock()
+
+	if s.Context.Sampled && s.exporter != nil {
+		s.exporter.ExportSpan(s)
+	}
+}
+
+// SpanExporter receives ended spans, e.g. to forward them to an OpenTelemetry collector
+// ExportSpan is called on the goroutine that ended the span so it should return quickly
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span, spans started from it become its children
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span of ctx, handlers can use it to pass the trace on to other services
+// e.g. req.Header.Set("traceparent", socketify.SpanFromContext(ctx).Context.Traceparent())
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// startSpan starts a span that is a child of parent, or the root of a new trace when parent is invalid
+func startSpan(exporter SpanExporter, name string, kind SpanKind, parent SpanContext) *Span {
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Parent:     parent,
+		StartTime:  time.Now(),
+		Attributes: map[string]interface{}{},
+		exporter:   exporter,
+	}
+
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+	} else {
+		_, _ = rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = true
+	}
+
+	_, _ = rand.Read(span.Context.SpanID[:])
+
+	return span
+}
+
+// startIncomingSpan starts the span of an incoming update, continuing the trace of its traceparent if it has a valid one
+func startIncomingSpan(exporter SpanExporter, update *Update) *Span {
+	if exporter == nil {
+		return nil
+	}
+
+	var parent SpanContext
+	if update.Traceparent != "" {
+		parent, _ = ParseTraceparent(update.Traceparent)
+	}
+
+	span := startSpan(exporter, "receive "+update.Type, SpanKindConsumer, parent)
+	span.Attributes["update_type"] = update.Type
+	if update.Extra != "" {
+		span.Attributes["extra"] = update.Extra
+	}
+
+	return span
+}
+
+// traceUpdate returns the context handlers of update run with and the update's span, nil when tracing is disabled
+func (c *Connection) traceUpdate(update *Update) (context.Context, *Span) {
+	span := startIncomingSpan(c.server.opts.spanExporter, update)
+	if span == nil {
+		// Let WriteUpdateContext continue the client's trace even though nothing is exported
+		if parent, err := ParseTraceparent(update.Traceparent); err == nil {
+			return ContextWithSpan(c.ctx, &Span{Context: parent}), nil
+		}
+		return c.ctx, nil
+	}
+
+	span.Attributes["connection_id"] = c.id
+
+	return ContextWithSpan(c.ctx, span), span
+}
+
+// traceUpdate is like Connection.traceUpdate for the handlers of a client, the context is cancelled when it closes
+func (c *Client) traceUpdate(update *Update) (context.Context, *Span) {
+	span := startIncomingSpan(c.opts.spanExporter, update)
+	if span == nil {
+		if parent, err := ParseTraceparent(update.Traceparent); err == nil {
+			return ContextWithSpan(c.ctx, &Span{Context: parent}), nil
+		}
+		return c.ctx, nil
+	}
+
+	return ContextWithSpan(c.ctx, span), span
+}
+
+// InMemoryExporter keeps exported spans in memory, it's meant for tests
+type InMemoryExporter struct {
+	m     sync.Mutex
+	spans []*Span
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) ExportSpan(span *Span) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the exported spans in the order they ended
+func (e *InMemoryExporter) Spans() []*Span {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return append([]*Span(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.spans = nil
+}
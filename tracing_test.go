@@ -0,0 +1,209 @@
+package socketify_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aliforever/go-socketify"
+	"github.com/aliforever/go-socketify/socketifytest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		wantErr     bool
+		sampled     bool
+	}{
+		{name: "Sampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true},
+		{name: "NotSampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{name: "FutureVersion", traceparent: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", sampled: true},
+		{name: "Empty", traceparent: "", wantErr: true},
+		{name: "InvalidVersion", traceparent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "ZeroTraceID", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "ShortSpanID", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba9-01", wantErr: true},
+		{name: "NotHex", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc, err := socketify.ParseTraceparent(test.traceparent)
+			if test.wantErr {
+				assert.ErrorIs(t, err, socketify.ErrInvalidTraceparent)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.sampled, sc.Sampled)
+			if test.traceparent[:2] == "00" {
+				assert.Equal(t, test.traceparent, sc.Traceparent())
+			}
+		})
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	serverSpans := socketify.NewInMemoryExporter()
+	clientSpans := socketify.NewInMemoryExporter()
+
+	server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetSpanExporter(serverSpans)), func(c *socketify.Connection) {
+		c.HandleUpdate("ping", socketify.DataMapperContext[int](func(ctx context.Context, n int, extra ...string) error {
+			return c.WriteUpdateContext(ctx, "pong", n, extra...)
+		}))
+	})
+
+	got, _ := server.PairWith(func(url string, dialer *websocket.Dialer) (*socketify.Client, error) {
+		return socketify.NewClientWithOptions(url, socketify.ClientOptions().SetLogger(socketify.NopLogger()).SetDialer(dialer).SetSpanExporter(clientSpans))
+	})
+
+	pongs := make(chan struct{}, 3)
+
+	// Replaces the harness' raw handler so updates reach the update type handlers
+	got.SetRawHandler(nil)
+	got.SetUpdateTypeHandler("pong", func(json.RawMessage) {
+		pongs <- struct{}{}
+	})
+
+	for n := 1; n <= 3; n++ {
+		got.SendUpdate("ping", n, strconv.Itoa(n))
+	}
+
+	for n := 1; n <= 3; n++ {
+		select {
+		case <-pongs:
+		case <-time.After(time.Second):
+			t.Fatal("no pong received")
+		}
+	}
+
+	// Spans end after the handler returns and after the write is done, which can be after the peer got the update
+	assert.Eventually(t, func() bool {
+		return len(clientSpans.Spans()) == 6 && len(serverSpans.Spans()) == 6
+	}, time.Second, time.Millisecond*10)
+
+	byName := func(spans []*socketify.Span) map[string][]*socketify.Span {
+		names := map[string][]*socketify.Span{}
+		for _, span := range spans {
+			names[span.Name] = append(names[span.Name], span)
+		}
+		return names
+	}
+
+	client, srv := byName(clientSpans.Spans()), byName(serverSpans.Spans())
+
+	// One span per update on each side
+	assert.Len(t, client["send ping"], 3)
+	assert.Len(t, srv["receive ping"], 3)
+	assert.Len(t, srv["send pong"], 3)
+	assert.Len(t, client["receive pong"], 3)
+
+	for n := 0; n < 3; n++ {
+		send := client["send ping"][n]
+
+		var receive *socketify.Span
+		for _, span := range srv["receive ping"] {
+			if span.Parent == send.Context {
+				receive = span
+			}
+		}
+		if !assert.NotNil(t, receive, "the server didn't continue the client's trace") {
+			continue
+		}
+		assert.Equal(t, socketify.SpanKindConsumer, receive.Kind)
+		assert.Equal(t, strconv.Itoa(n+1), receive.Attributes["extra"])
+
+		var reply *socketify.Span
+		for _, span := range srv["send pong"] {
+			if span.Parent == receive.Context {
+				reply = span
+			}
+		}
+		if !assert.NotNil(t, reply, "the reply isn't a child of the update's span") {
+			continue
+		}
+		assert.Equal(t, socketify.SpanKindProducer, reply.Kind)
+
+		var received *socketify.Span
+		for _, span := range client["receive pong"] {
+			if span.Parent == reply.Context {
+				received = span
+			}
+		}
+		if assert.NotNil(t, received, "the client didn't continue the server's trace") {
+			assert.Equal(t, send.Context.TraceID, received.Context.TraceID)
+		}
+	}
+}
+
+func TestClientHandlerContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter *socketify.InMemoryExporter
+	}{
+		{name: "Exporter", exporter: socketify.NewInMemoryExporter()},
+		{name: "NoExporter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverSpans := socketify.NewInMemoryExporter()
+
+			server := socketifytest.NewPipeServer(t, socketify.NewServer(socketify.ServerOptions().SetLogger(socketify.NopLogger()).SetSpanExporter(serverSpans)), func(c *socketify.Connection) {
+				c.HandleUpdate("ping", socketify.DataMapperContext[int](func(ctx context.Context, n int, extra ...string) error {
+					return c.WriteUpdateContext(ctx, "pong", n, extra...)
+				}))
+			})
+
+			got, _ := server.PairWith(func(url string, dialer *websocket.Dialer) (*socketify.Client, error) {
+				options := socketify.ClientOptions().SetLogger(socketify.NopLogger()).SetDialer(dialer)
+				if tt.exporter != nil {
+					options.SetSpanExporter(tt.exporter)
+				}
+				return socketify.NewClientWithOptions(url, options)
+			})
+
+			spans := make(chan *socketify.Span, 1)
+
+			got.SetRawHandler(nil)
+			got.SetUpdateTypeHandlerContext("pong", func(ctx context.Context, _ json.RawMessage) {
+				spans <- socketify.SpanFromContext(ctx)
+			})
+
+			got.SendUpdate("ping", 1)
+
+			var span *socketify.Span
+			select {
+			case span = <-spans:
+			case <-time.After(time.Second):
+				t.Fatal("no pong received")
+			}
+
+			var reply *socketify.Span
+			assert.Eventually(t, func() bool {
+				for _, s := range serverSpans.Spans() {
+					if s.Name == "send pong" {
+						reply = s
+						return true
+					}
+				}
+				return false
+			}, time.Second, time.Millisecond*10)
+
+			if !assert.NotNil(t, span, "the handler's context has no span") || reply == nil {
+				return
+			}
+
+			if tt.exporter == nil {
+				assert.Equal(t, reply.Context, span.Context)
+				return
+			}
+
+			assert.Equal(t, "receive pong", span.Name)
+			assert.Equal(t, reply.Context, span.Parent)
+		})
+	}
+}
@@ -6,10 +6,15 @@ type Update struct {
 	Type  string          `json:"type"`
 	Data  json.RawMessage `json:"data,omitempty"`
 	Extra string          `json:"extra,omitempty"`
+	// Traceparent is the W3C trace context of the sender, see ParseTraceparent
+	Traceparent string `json:"traceparent,omitempty"`
 }
 
 type serverUpdate struct {
-	Type  string      `json:"type"`
-	Data  interface{} `json:"data,omitempty"`
-	Extra string      `json:"extra,omitempty"`
+	Type  string                 `json:"type"`
+	Data  interface{}            `json:"data,omitempty"`
+	Extra string                 `json:"extra,omitempty"`
+	Meta  map[string]interface{} `json:"meta,omitempty"`
+
+	Traceparent string `json:"traceparent,omitempty"`
 }
@@ -32,6 +32,7 @@ func (u *UpgradeRequest) SetClientID(clientID string) *UpgradeRequest {
 	return u
 }
 
+// SetAttribute sets an attribute of the connection before it's upgraded, it's copied to the connection by Upgrade
 func (u *UpgradeRequest) SetAttribute(key string, val interface{}) *UpgradeRequest {
 	u.attributes[key] = val
 
@@ -43,6 +44,8 @@ func (u *UpgradeRequest) WriteResponse(statusCode int, header http.Header, respo
 		u.done <- true
 	}()
 
+	u.server.metrics.upgradeRejected("application")
+
 	u.wr.WriteHeader(statusCode)
 
 	for key, values := range header {
@@ -58,11 +61,24 @@ func (u *UpgradeRequest) Request() *http.Request {
 	return u.r
 }
 
+// RemoteIP returns the client's IP, X-Forwarded-For is only trusted for the proxies set by options.SetTrustedProxies
+func (u *UpgradeRequest) RemoteIP() string {
+	return remoteIP(u.r, u.server.trustedProxies)
+}
+
 func (u *UpgradeRequest) Upgrade() (*Connection, error) {
 	defer func() {
 		u.done <- true
 	}()
 
+	release, status := u.server.connLimiter.reserve(u.server.opts, u.RemoteIP(), u.attributes)
+	if release == nil {
+		http.Error(u.wr, ErrTooManyConnections.Error(), status)
+		u.server.opts.logger.Warn("Connection limit reached", "remote_ip", u.RemoteIP(), "status", status)
+		u.server.metrics.upgradeRejected("connection_limit")
+		return nil, ErrTooManyConnections
+	}
+
 	var ef *encryptionFields
 
 	if u.server.opts.encryption != nil {
@@ -72,7 +88,9 @@ func (u *UpgradeRequest) Upgrade() (*Connection, error) {
 			if err != nil {
 				u.wr.WriteHeader(http.StatusBadRequest)
 				u.wr.Write([]byte(err.Error()))
-				u.server.opts.logger.Error("Error parsing rsa public key from connection", err)
+				u.server.opts.logger.Error("Error parsing rsa public key from connection", "error", err, "remote_addr", u.r.RemoteAddr)
+				u.server.metrics.upgradeRejected("encryption")
+				release()
 				return nil, err
 			}
 
@@ -84,7 +102,9 @@ func (u *UpgradeRequest) Upgrade() (*Connection, error) {
 			if err != nil {
 				u.wr.WriteHeader(http.StatusInternalServerError)
 				u.wr.Write([]byte(err.Error()))
-				u.server.opts.logger.Error("Error getting server private key", err)
+				u.server.opts.logger.Error("Error getting server private key", "error", err)
+				u.server.metrics.upgradeRejected("encryption")
+				release()
 				return nil, err
 			}
 
@@ -96,7 +116,9 @@ func (u *UpgradeRequest) Upgrade() (*Connection, error) {
 
 	c, err := u.server.upgrade.Upgrade(u.wr, u.r, headers)
 	if err != nil {
-		u.server.opts.logger.Error("Error upgrading request", err, fmt.Sprintf("Headers: %+v", u.r.Header))
+		u.server.opts.logger.Error("Error upgrading request", "error", err, "remote_addr", u.r.RemoteAddr, "headers", fmt.Sprintf("%+v", u.r.Header))
+		u.server.metrics.upgradeRejected("upgrade")
+		release()
 		return nil, err
 	}
 
@@ -104,10 +126,16 @@ func (u *UpgradeRequest) Upgrade() (*Connection, error) {
 		u.clientID = shortid.MustGenerate()
 	}
 
-	connection := newConnection(u.server, c, u.clientID, ef)
+	u.server.metrics.upgradeAccepted()
+
+	connection := newConnection(u.server, c, u.clientID, ef, u.attributes)
+	connection.release = release
 	if u.server.storage != nil {
 		u.server.storage.addClient(connection)
 	}
+	if u.server.opts.broker != nil {
+		u.server.subscribeConnection(connection)
+	}
 
 	return connection, nil
 }
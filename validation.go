@@ -0,0 +1,428 @@
+package socketify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError describes a single invalid field, Field is the JSON path of the field (e.g. "items[0].name")
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by DataMapper handlers when the update's data is invalid
+// It lists every invalid field and is sent back to the client as a "validation_error" update
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", field.Field, field.Message))
+	}
+
+	return "validation_failed: " + strings.Join(messages, "; ")
+}
+
+var regexCache sync.Map
+
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// decodeError converts json decoding errors into a *ValidationError when possible
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &ValidationError{Fields: []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: fmt.Sprintf("must be %s", typeErr.Type),
+		}}}
+	}
+
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		field, _ := strconv.Unquote(strings.TrimPrefix(msg, "json: unknown field "))
+		return &ValidationError{Fields: []FieldError{{
+			Field:   field,
+			Rule:    "unknown",
+			Message: "unknown field",
+		}}}
+	}
+
+	return err
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// decodeErrors walks raw the way encoding/json decodes it into t and lists every field of the wrong type,
+// and every unknown field when strict is set, instead of stopping at the first one like the decoder does
+func decodeErrors(t reflect.Type, raw json.RawMessage, path string, strict bool) []FieldError {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if !reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		switch t.Kind() {
+		case reflect.Struct:
+			var fields map[string]json.RawMessage
+			if json.Unmarshal(raw, &fields) == nil {
+				return structDecodeErrors(t, fields, path, strict)
+			}
+		case reflect.Slice, reflect.Array:
+			var items []json.RawMessage
+			if t.Elem().Kind() != reflect.Uint8 && json.Unmarshal(raw, &items) == nil {
+				var errs []FieldError
+				for i, item := range items {
+					errs = append(errs, decodeErrors(t.Elem(), item, fmt.Sprintf("%s[%d]", path, i), strict)...)
+				}
+				return errs
+			}
+		case reflect.Map:
+			var values map[string]json.RawMessage
+			if t.Key().Kind() == reflect.String && json.Unmarshal(raw, &values) == nil {
+				keys := make([]string, 0, len(values))
+				for key := range values {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+
+				var errs []FieldError
+				for _, key := range keys {
+					errs = append(errs, decodeErrors(t.Elem(), values[key], joinPath(path, key), strict)...)
+				}
+				return errs
+			}
+		}
+	}
+
+	if err := json.Unmarshal(raw, reflect.New(t).Interface()); err != nil {
+		want := t
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			want = typeErr.Type
+		}
+		return []FieldError{{Field: path, Rule: "type", Message: fmt.Sprintf("must be %s", want)}}
+	}
+
+	return nil
+}
+
+func structDecodeErrors(t reflect.Type, fields map[string]json.RawMessage, path string, strict bool) []FieldError {
+	var errs []FieldError
+	var names []string
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+
+			if field.Anonymous && field.Tag.Get("json") == "" && fieldType.Kind() == reflect.Struct {
+				walk(fieldType)
+				continue
+			}
+
+			name := jsonFieldName(field)
+			if name == "-" {
+				continue
+			}
+			names = append(names, name)
+
+			if raw, ok := lookupField(fields, name); ok {
+				errs = append(errs, decodeErrors(field.Type, raw, joinPath(path, name), strict)...)
+			}
+		}
+	}
+	walk(t)
+
+	if !strict {
+		return errs
+	}
+
+	var unknown []string
+	for key := range fields {
+		known := false
+		for _, name := range names {
+			if strings.EqualFold(key, name) {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+
+	for _, key := range unknown {
+		errs = append(errs, FieldError{Field: joinPath(path, key), Rule: "unknown", Message: "unknown field"})
+	}
+
+	return errs
+}
+
+// withoutFieldsOf drops the errors of errs that are about one of fields or something nested in it
+func withoutFieldsOf(errs []FieldError, fields []FieldError) []FieldError {
+	kept := errs[:0]
+	for _, err := range errs {
+		nested := false
+		for _, field := range fields {
+			if field.Field == "" || err.Field == field.Field || strings.HasPrefix(err.Field, field.Field+".") || strings.HasPrefix(err.Field, field.Field+"[") {
+				nested = true
+				break
+			}
+		}
+
+		if !nested {
+			kept = append(kept, err)
+		}
+	}
+
+	return kept
+}
+
+// validateTags checks the `validate` struct tags of v against the raw JSON it was decoded from
+// Supported rules: required, min=n, max=n, enum=a|b|c, regex=pattern
+// min and max compare numbers by value and strings, slices and maps by length
+// The regex rule must be the last one since the pattern may contain commas
+func validateTags(v reflect.Value, raw json.RawMessage, path string) []FieldError {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return validateStruct(v, raw, path)
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		_ = json.Unmarshal(raw, &items)
+
+		var errs []FieldError
+		for i := 0; i < v.Len(); i++ {
+			var item json.RawMessage
+			if i < len(items) {
+				item = items[i]
+			}
+			errs = append(errs, validateTags(v.Index(i), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	}
+
+	return nil
+}
+
+func validateStruct(v reflect.Value, raw json.RawMessage, path string) []FieldError {
+	var fields map[string]json.RawMessage
+	_ = json.Unmarshal(raw, &fields)
+
+	var errs []FieldError
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			errs = append(errs, validateTags(v.Field(i), raw, path)...)
+			continue
+		}
+
+		name := jsonFieldName(field)
+		if name == "-" {
+			continue
+		}
+
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+
+		fieldRaw, present := lookupField(fields, name)
+		present = present && string(fieldRaw) != "null"
+
+		errs = append(errs, validateField(v.Field(i), field.Tag.Get("validate"), present, fieldPath)...)
+
+		if present {
+			errs = append(errs, validateTags(v.Field(i), fieldRaw, fieldPath)...)
+		}
+	}
+
+	return errs
+}
+
+// lookupField finds the value of a field like encoding/json does: an exact match first, then a case-insensitive one
+func lookupField(fields map[string]json.RawMessage, name string) (json.RawMessage, bool) {
+	if raw, ok := fields[name]; ok {
+		return raw, true
+	}
+
+	for key, raw := range fields {
+		if strings.EqualFold(key, name) {
+			return raw, true
+		}
+	}
+
+	return nil, false
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+
+	return name
+}
+
+func validateField(v reflect.Value, tag string, present bool, path string) []FieldError {
+	if tag == "" {
+		return nil
+	}
+
+	var errs []FieldError
+
+	fail := func(rule, message string) {
+		errs = append(errs, FieldError{Field: path, Rule: rule, Message: message})
+	}
+
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	rules := tag
+	for rules != "" {
+		var rule string
+		if strings.HasPrefix(rules, "regex=") {
+			rule, rules = rules, ""
+		} else {
+			rule, rules, _ = strings.Cut(rules, ",")
+		}
+
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+
+		if name == "required" {
+			if !present {
+				fail("required", "is required")
+			}
+			continue
+		}
+
+		if !present {
+			continue
+		}
+
+		switch name {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				fail(name, fmt.Sprintf("invalid rule %q", rule))
+				continue
+			}
+
+			size, isLength, ok := measure(v)
+			if !ok {
+				continue
+			}
+
+			if (name == "min" && size < limit) || (name == "max" && size > limit) {
+				what := "must be"
+				if isLength {
+					what = "length must be"
+				}
+				op := "at least"
+				if name == "max" {
+					op = "at most"
+				}
+				fail(name, fmt.Sprintf("%s %s %s", what, op, arg))
+			}
+		case "enum":
+			value := fmt.Sprint(v.Interface())
+
+			allowed := strings.Split(arg, "|")
+			found := false
+			for _, a := range allowed {
+				if a == value {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				fail("enum", fmt.Sprintf("must be one of %s", strings.Join(allowed, ", ")))
+			}
+		case "regex":
+			if v.Kind() != reflect.String {
+				continue
+			}
+
+			re, err := compileRegex(arg)
+			if err != nil {
+				fail("regex", fmt.Sprintf("invalid rule %q", rule))
+				continue
+			}
+
+			if !re.MatchString(v.String()) {
+				fail("regex", fmt.Sprintf("must match %s", arg))
+			}
+		}
+	}
+
+	return errs
+}
+
+// measure returns the value of numbers and the length of strings, slices and maps
+func measure(v reflect.Value) (size float64, isLength bool, ok bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true, true
+	}
+
+	return 0, false, false
+}
@@ -0,0 +1,188 @@
+package socketify_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aliforever/go-socketify"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type transferItem struct {
+	Name string `json:"name" validate:"required,regex=^[a-z]+$"`
+}
+
+type transferInput struct {
+	Amount   int            `json:"amount" validate:"required,min=1,max=1000"`
+	Currency string         `json:"currency" validate:"required,enum=USD|EUR"`
+	Note     string         `json:"note" validate:"max=5"`
+	Items    []transferItem `json:"items" validate:"max=2"`
+}
+
+func TestDataMapperValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		strict     bool
+		wantFields []string
+	}{
+		{
+			name: "Valid",
+			data: `{"amount":10,"currency":"USD","items":[{"name":"abc"}]}`,
+		},
+		{
+			name:       "MissingRequired",
+			data:       `{"note":"hi"}`,
+			wantFields: []string{"amount", "currency"},
+		},
+		{
+			name:       "OutOfRange",
+			data:       `{"amount":0,"currency":"GBP","note":"too long"}`,
+			wantFields: []string{"amount", "currency", "note"},
+		},
+		{
+			name:       "Nested",
+			data:       `{"amount":5,"currency":"EUR","items":[{"name":"ok"},{"name":"NO"},{}]}`,
+			wantFields: []string{"items", "items[1].name", "items[2].name"},
+		},
+		{
+			name:       "WrongType",
+			data:       `{"amount":"ten","currency":"EUR"}`,
+			wantFields: []string{"amount"},
+		},
+		{
+			name:       "UnknownFieldStrict",
+			data:       `{"amount":5,"currency":"EUR","extra":true}`,
+			strict:     true,
+			wantFields: []string{"extra"},
+		},
+		{
+			name:   "CaseInsensitiveNames",
+			data:   `{"Amount":10,"CURRENCY":"USD"}`,
+			strict: true,
+		},
+		{
+			name:       "CaseInsensitiveOutOfRange",
+			data:       `{"Amount":0,"Currency":"USD"}`,
+			wantFields: []string{"amount"},
+		},
+		{
+			name:       "WrongTypesAndTags",
+			data:       `{"amount":"ten","currency":"GBP","note":1,"items":[{"name":"ok"},{"name":2}]}`,
+			wantFields: []string{"amount", "note", "items[1].name", "currency"},
+		},
+		{
+			name:       "WrongTypeUnknownFieldsAndTagsStrict",
+			data:       `{"amount":"ten","currency":"GBP","zed":1,"extra":true,"items":[{"name":"NO","x":1}]}`,
+			strict:     true,
+			wantFields: []string{"amount", "items[0].x", "extra", "zed", "currency", "items[0].name"},
+		},
+		{
+			name:       "NotAnObject",
+			data:       `"ten"`,
+			wantFields: []string{""},
+		},
+		{
+			name: "UnknownFieldNotStrict",
+			data: `{"amount":5,"currency":"EUR","extra":true}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+
+			mapper := socketify.DataMapper[transferInput](func(transferInput, ...string) error {
+				called = true
+				return nil
+			}).Validate()
+
+			if tt.strict {
+				mapper = mapper.Strict()
+			}
+
+			err := mapper.Handle(context.Background(), json.RawMessage(tt.data))
+
+			if len(tt.wantFields) == 0 {
+				assert.NoError(t, err)
+				assert.True(t, called)
+				return
+			}
+
+			var validationErr *socketify.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+
+			var fields []string
+			for _, field := range validationErr.Fields {
+				fields = append(fields, field.Field)
+			}
+
+			assert.Equal(t, tt.wantFields, fields)
+			assert.False(t, called)
+		})
+	}
+}
+
+func TestDataMapperWithSchema(t *testing.T) {
+	schema := []byte(`{
+		"type": "object",
+		"required": ["symbol", "qty"],
+		"additionalProperties": false,
+		"properties": {
+			"symbol": {"type": "string", "pattern": "^[A-Z]{3,5}$"},
+			"qty": {"type": "integer", "minimum": 1},
+			"side": {"enum": ["buy", "sell"]}
+		}
+	}`)
+
+	tests := []struct {
+		name       string
+		data       string
+		wantFields []string
+	}{
+		{
+			name: "Valid",
+			data: `{"symbol":"BTC","qty":2,"side":"buy"}`,
+		},
+		{
+			name:       "Invalid",
+			data:       `{"symbol":"btc","qty":1.5,"side":"hold","foo":1}`,
+			wantFields: []string{"foo", "qty", "side", "symbol"},
+		},
+		{
+			name:       "Missing",
+			data:       `{}`,
+			wantFields: []string{"symbol", "qty"},
+		},
+	}
+
+	mapper := socketify.DataMapper[map[string]interface{}](func(map[string]interface{}, ...string) error {
+		return nil
+	}).WithSchema(schema)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mapper.Handle(context.Background(), json.RawMessage(tt.data))
+
+			if len(tt.wantFields) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *socketify.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+
+			var fields []string
+			for _, field := range validationErr.Fields {
+				fields = append(fields, field.Field)
+			}
+
+			assert.Equal(t, tt.wantFields, fields)
+		})
+	}
+}
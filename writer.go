@@ -1,23 +1,63 @@
 package socketify
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type writer struct {
 	ch     chan messageType
+	done   <-chan struct{}
 	logger Logger
+
+	connection       *Connection
+	metrics          *metrics
+	spanExporter     SpanExporter
+	queued           int64
+	recorder         atomic.Pointer[Recorder]
+	interceptors     []OutgoingInterceptor
+	interceptorsLock sync.Mutex
 }
 
-func newWriter(ch chan messageType, logger Logger) *writer {
-	w := &writer{ch: ch, logger: logger}
+func newWriter(ch chan messageType, done <-chan struct{}, logger Logger) *writer {
+	w := &writer{ch: ch, done: done, logger: logger}
 	return w
 }
 
+// write hands the message to processWriter and waits for the result
+// It returns ErrConnectionClosed instead of blocking once the connection is closed
+// The channel is unbuffered, so the queue depth counts the write calls waiting for processWriter or being written
+func (w *writer) write(m messageType) error {
+	w.metrics.writeQueued(1)
+	atomic.AddInt64(&w.queued, 1)
+
+	defer func() {
+		w.metrics.writeQueued(-1)
+		atomic.AddInt64(&w.queued, -1)
+	}()
+
+	select {
+	case w.ch <- m:
+	case <-w.done:
+		return ErrConnectionClosed
+	}
+
+	return <-m.Err()
+}
+
 func (w *writer) WriteUpdate(updateType string, data interface{}, extra ...string) (err error) {
+	return w.WriteUpdateContext(context.Background(), updateType, data, extra...)
+}
+
+// WriteUpdateContext is like WriteUpdate but the update continues the trace of the span in ctx
+// Pass the context of a handler to link its replies to the update it's handling
+func (w *writer) WriteUpdateContext(ctx context.Context, updateType string, data interface{}, extra ...string) (err error) {
 	su := serverUpdate{
 		Type: updateType,
 		Data: data,
@@ -26,60 +66,92 @@ func (w *writer) WriteUpdate(updateType string, data interface{}, extra ...strin
 		su.Extra = strings.Join(extra, "_")
 	}
 
-	jm := newJSONMessage(su)
+	var parent SpanContext
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.Context
+	}
 
-	w.ch <- jm
+	if w.spanExporter != nil {
+		span := startSpan(w.spanExporter, "send "+updateType, SpanKindProducer, parent)
+		span.Attributes["update_type"] = updateType
+		if w.connection != nil {
+			span.Attributes["connection_id"] = w.connection.id
+		}
+		defer func() {
+			span.RecordError(err)
+			span.End()
+		}()
+
+		su.Traceparent = span.Context.Traceparent()
+	} else if parent.IsValid() {
+		su.Traceparent = parent.Traceparent()
+	}
 
-	return <-jm.err
+	jm := newJSONMessage(su)
+
+	return w.write(jm)
 }
 
 func (w *writer) WriteRawUpdate(data interface{}) (err error) {
 	jm := newJSONMessage(data)
 
-	w.ch <- jm
-
-	return <-jm.err
+	return w.write(jm)
 }
 
 func (w *writer) WriteBinaryBytes(data []byte) (err error) {
 	jm := newBinaryMessage(data)
 
-	w.ch <- jm
-
-	return <-jm.err
+	return w.write(jm)
 }
 
 func (w *writer) WriteBinaryText(data []byte) (err error) {
 	jm := newBinaryTextMessage(data)
 
-	w.ch <- jm
-
-	return <-jm.err
+	return w.write(jm)
 }
 
 func (w *writer) WriteText(data string) (err error) {
 	jm := newTextMessage(data)
 
-	w.ch <- jm
-
-	return <-jm.err
+	return w.write(jm)
 }
 
 func (w *writer) processWriter(ws *websocket.Conn) {
-	for update := range w.ch {
+	for {
+		var update messageType
+
+		select {
+		case update = <-w.ch:
+		case <-w.done:
+			return
+		}
+
+		if err := w.intercept(update); err != nil {
+			go func(update messageType, err error) {
+				update.Err() <- err
+			}(update, err)
+			if !errors.Is(err, ErrFrameDropped) {
+				w.logger.Error("Error from outgoing interceptor", "error", err, "update", fmt.Sprintf("%+v", update))
+			}
+			continue
+		}
+
 		data, err := update.Data()
 		if err != nil {
 			go func(update messageType, err error) {
 				update.Err() <- err
 			}(update, err)
-			w.logger.Error("Error getting message data", err, fmt.Sprintf("update: %+v . RemoteAddr: %s", update, ws.RemoteAddr().String()))
+			w.logger.Error("Error getting message data", "error", err, "update", fmt.Sprintf("%+v", update))
 			continue
 		}
 
 		// TODO: Encrypt Message if this is an encrypted connection
 		err = ws.WriteMessage(update.Type(), data)
 		if err != nil {
-			w.logger.Error("Error writing JSON", err, fmt.Sprintf("update: %+v . RemoteAddr: %s", update, ws.RemoteAddr().String()))
+			w.logger.Error("Error writing message", "error", err, "update", fmt.Sprintf("%+v", update))
+		} else {
+			w.recordFrame(FrameDirectionOut, update.Type(), data)
+			w.metrics.messageSent(outgoingUpdateType(update), len(data))
 		}
 		go func(update messageType, err error) {
 			update.Err() <- err
@@ -96,10 +168,17 @@ func (c *Connection) WriteInternalUpdate(update []byte) {
 func (c *Connection) ping() {
 	if c.keepAlive != 0 {
 		ticker := time.NewTicker(c.keepAlive)
-		for range ticker.C {
-			err := c.ws.WriteMessage(websocket.PingMessage, nil)
-			if err != nil {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.ctx.Done():
 				return
+			case <-ticker.C:
+				err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.keepAlive))
+				if err != nil {
+					return
+				}
 			}
 		}
 	}